@@ -9,6 +9,7 @@ import (
 
 	"net/http"
 	"os"
+	"time"
 
 	// We're using sqlite implementation of the sql interface.
 	_ "github.com/mattn/go-sqlite3"
@@ -23,6 +24,9 @@ type App struct {
 	DB     *sql.DB
 	Mutex  sync.Mutex
 	Config AppConfig
+
+	webhooks *WebhookDispatcher
+	events   *eventBroker
 }
 
 // AppConfig holds the configuration used by the application.
@@ -30,19 +34,81 @@ type AppConfig struct {
 	Port          string
 	TemplatesPath string
 	DbPath        string
+	// LinkCheckInterval is how often every link is checked, 0 disables the checks.
+	LinkCheckInterval    time.Duration
+	LinkCheckConcurrency int
+	LinkCheckHostDelay   time.Duration
+	LinkCheckTimeout     time.Duration
+	// LinkCheckPrivate checks the links to loopback and private addresses
+	// too, which lets anyone storing a link make the server request them.
+	LinkCheckPrivate bool
+	// StaleAfter is how long a SwDoc can go without updates before it is stale, 0 disables it.
+	StaleAfter time.Duration
+	// WebhookRetries is how many times a failed webhook delivery is retried,
+	// the first retry is after WebhookBackoff and each next one waits twice as long.
+	WebhookRetries int
+	WebhookBackoff time.Duration
+	WebhookTimeout time.Duration
+	// WebhookPrivate delivers to receivers on loopback and private addresses
+	// too, which lets anyone registering a webhook make the server request them.
+	WebhookPrivate bool
+	// TrashRetention is how long deleted SwDocs can be restored before they
+	// are purged, 0 keeps them forever.
+	TrashRetention time.Duration
+	// Timezone is where the dates of the web pages are shown unless people
+	// pick another one, UTC when nil.
+	Timezone *time.Location
 }
 
+// trashPurgeInterval is how often the SwDocs past the trash retention are purged.
+const trashPurgeInterval = time.Hour
+
 func (a *App) initializeRoutes() {
 	// Web Pages
 	a.Router.HandleFunc("/", a.homeHandler).Methods("GET")
 	a.Router.HandleFunc("/search", a.searchHandler).Methods("GET")
+	a.Router.HandleFunc("/go/{swDocName}/{linkID}", a.goLinkHandler).Methods("GET")
+	a.Router.HandleFunc("/feed.{format:atom|rss}", a.feedHandler).Methods("GET")
+	a.Router.HandleFunc("/graph", a.graphHandler).Methods("GET")
+	a.Router.HandleFunc("/all", a.browseHandler).Methods("GET")
+	a.Router.HandleFunc("/users/{user}", a.userHandler).Methods("GET")
+	a.Router.HandleFunc("/teams/{team}", a.teamHandler).Methods("GET")
+	a.Router.HandleFunc("/new", a.newSwDocHandler).Methods("GET")
+	a.Router.HandleFunc("/new", a.submitSwDocHandler).Methods("POST")
 	a.Router.HandleFunc("/{swDocName}", a.swDocHandler).Methods("GET")
+	a.Router.HandleFunc("/{swDocName}/feed.{format:atom|rss}", a.feedHandler).Methods("GET")
+	a.Router.HandleFunc("/{swDocName}/graph", a.graphHandler).Methods("GET")
+	a.Router.HandleFunc("/{swDocName}/edit", a.editSwDocHandler).Methods("GET")
+	a.Router.HandleFunc("/{swDocName}/edit", a.submitSwDocHandler).Methods("POST")
+	a.Router.HandleFunc("/{swDocName}/delete", a.deleteSwDocPageHandler).Methods("GET")
+	a.Router.HandleFunc("/{swDocName}/delete", a.submitDeleteSwDocHandler).Methods("POST")
+	a.Router.HandleFunc("/{swDocName}/{slug}", a.swDocSlugHandler).Methods("GET")
 	// REST API
 	a.Router.HandleFunc("/api/v1/swdocs/", a.getSwDocsHandler).Methods("GET")
 	a.Router.HandleFunc("/api/v1/swdocs/{swDocName}", a.getSwDocHandler).Methods("GET")
 	a.Router.HandleFunc("/api/v1/swdocs/{swDocName}", a.deleteSwDocHandler).Methods("DELETE")
+	a.Router.HandleFunc("/api/v1/swdocs/{swDocName}", a.patchSwDocHandler).Methods("PATCH")
+	a.Router.HandleFunc("/api/v1/swdocs/{swDocName}/rename", a.renameSwDocHandler).Methods("POST")
+	a.Router.HandleFunc("/api/v1/swdocs/{swDocName}/stats", a.getSwDocStatsHandler).Methods("GET")
+	a.Router.HandleFunc("/api/v1/swdocs/{swDocName}/related", a.getRelatedSwDocsHandler).Methods("GET")
+	a.Router.HandleFunc("/api/v1/stats", a.getStatsHandler).Methods("GET")
+	a.Router.HandleFunc("/api/v1/links/broken", a.getBrokenLinksHandler).Methods("GET")
+	a.Router.HandleFunc("/api/v1/reports/stale", a.getStaleReportHandler).Methods("GET")
+	a.Router.HandleFunc("/api/v1/events", a.eventsHandler).Methods("GET")
+	a.Router.HandleFunc("/api/v1/graph", a.getGraphHandler).Methods("GET")
+	a.Router.HandleFunc("/api/v1/users/{user}", a.getUserHandler).Methods("GET")
+	a.Router.HandleFunc("/api/v1/teams/{team}", a.getTeamHandler).Methods("GET")
+	a.Router.HandleFunc("/api/v1/trash", a.getTrashHandler).Methods("GET")
+	a.Router.HandleFunc("/api/v1/trash", a.emptyTrashHandler).Methods("DELETE")
+	a.Router.HandleFunc("/api/v1/trash/{swDocName}", a.purgeSwDocHandler).Methods("DELETE")
+	a.Router.HandleFunc("/api/v1/trash/{swDocName}/restore", a.restoreSwDocHandler).Methods("POST")
+	a.Router.HandleFunc("/api/v1/webhooks", a.getWebhooksHandler).Methods("GET")
+	a.Router.HandleFunc("/api/v1/webhooks", a.createWebhookHandler).Methods("POST")
+	a.Router.HandleFunc("/api/v1/webhooks/{id:[0-9]+}", a.deleteWebhookHandler).Methods("DELETE")
+	a.Router.HandleFunc("/api/v1/webhooks/{id:[0-9]+}/deliveries", a.getWebhookDeliveriesHandler).Methods("GET")
+	a.Router.HandleFunc("/api/v1/webhooks/{id:[0-9]+}/ping", a.pingWebhookHandler).Methods("POST")
 	a.Router.HandleFunc("/api/v1/swdocs/apply", a.applySwDocHandler).Methods("POST")
-
+	a.Router.HandleFunc("/api/v1/swdocs/batch", a.applySwDocsBatchHandler).Methods("POST")
 }
 
 func (a *App) createDbIfNotExists() (bool, error) {
@@ -60,19 +126,35 @@ func (a *App) createDbIfNotExists() (bool, error) {
 	return true, nil
 }
 
-func (a *App) populateDb() error {
+func (a *App) migrateDb() error {
 	_, err := a.DB.Exec("PRAGMA encoding = \"UTF-8\";")
 	if err != nil {
 		return err
 	}
 
-	statement, err := a.DB.Prepare(dbSchema)
-	if err != nil {
+	// user_version tracks how many of the migrations were already applied.
+	var version int
+	if err := a.DB.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
 		return err
 	}
-	_, err = statement.Exec()
-	if err != nil {
-		return err
+
+	for i := version; i < len(dbMigrations); i++ {
+		log.Info(fmt.Sprintf("Applying database migration %d", i+1))
+		tx, err := a.DB.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(dbMigrations[i]); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -82,7 +164,7 @@ func (a *App) Initialize() {
 	var err error
 
 	// Create DB file if not exists.
-	exists, err := a.createDbIfNotExists()
+	_, err = a.createDbIfNotExists()
 	if err != nil {
 		log.Fatal(err.Error())
 	}
@@ -93,14 +175,15 @@ func (a *App) Initialize() {
 		log.Fatal(err)
 	}
 
-	// Create tables in DB if it didn't exist before.
-	if !exists {
-		err = a.populateDb()
-		if err != nil {
-			log.Fatal(err)
-		}
+	// Create or update the tables in the DB.
+	err = a.migrateDb()
+	if err != nil {
+		log.Fatal(err)
 	}
 
+	a.events = newEventBroker(a.DB)
+	a.webhooks = NewWebhookDispatcher(a.DB, &a.Mutex, NewWebhookClient(a.Config.WebhookTimeout, a.Config.WebhookPrivate), a.Config.WebhookRetries, a.Config.WebhookBackoff)
+
 	// Initialize the web app routes.
 	a.Router = mux.NewRouter()
 	a.initializeRoutes()
@@ -108,5 +191,43 @@ func (a *App) Initialize() {
 
 // Run the web application.
 func (a *App) Run() {
+	if a.Config.LinkCheckInterval > 0 {
+		checker := &LinkChecker{
+			DB:           a.DB,
+			Mutex:        &a.Mutex,
+			Client:       NewLinkCheckClient(a.Config.LinkCheckTimeout, a.Config.LinkCheckPrivate),
+			Interval:     a.Config.LinkCheckInterval,
+			Concurrency:  a.Config.LinkCheckConcurrency,
+			HostDelay:    a.Config.LinkCheckHostDelay,
+			AllowPrivate: a.Config.LinkCheckPrivate,
+		}
+		go checker.Run()
+	}
+
+	if a.Config.TrashRetention > 0 {
+		go a.purgeTrashForever()
+	}
+
+	go a.webhooks.Run()
+	go a.events.Run()
+
 	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", a.Config.Port), a.Router))
 }
+
+// purgeTrashForever purges the SwDocs in the trash for longer than the
+// retention every trashPurgeInterval.
+func (a *App) purgeTrashForever() {
+	for {
+		a.Mutex.Lock()
+		names, err := a.purgeTrashInTx(time.Now().Add(-a.Config.TrashRetention))
+		a.Mutex.Unlock()
+		if err != nil {
+			log.Error("Failed to purge the trash: " + err.Error())
+		} else if len(names) > 0 {
+			log.WithFields(log.Fields{
+				"swdocs": names,
+			}).Info("Purged the trash")
+		}
+		time.Sleep(trashPurgeInterval)
+	}
+}
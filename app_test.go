@@ -0,0 +1,29 @@
+package swdocs
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// newTestApp returns an App with a fresh database, without running its server.
+func newTestApp(t *testing.T) *App {
+	t.Helper()
+	a := &App{Config: AppConfig{
+		DbPath:        filepath.Join(t.TempDir(), "swdocs.sqlite"),
+		TemplatesPath: "templates",
+	}}
+	a.Initialize()
+	t.Cleanup(func() { a.DB.Close() })
+	return a
+}
+
+// applyTestSwDoc stores swdoc, failing the test if it can't.
+func applyTestSwDoc(t *testing.T, a *App, swdoc SwDoc) {
+	t.Helper()
+	if swdoc.User == "" {
+		swdoc.User = "test"
+	}
+	if err := a.applyInTx(&swdoc); err != nil {
+		t.Fatalf("applying %s: %v", swdoc.Name, err)
+	}
+}
@@ -0,0 +1,173 @@
+package swdocs
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// browsePageSize is how many SwDocs the browse page lists at once.
+const browsePageSize = 50
+
+// otherLetter is the index entry of the SwDocs not starting with a letter.
+const otherLetter = "#"
+
+// browsePage is the alphabetical index of every SwDoc, optionally only the
+// ones starting with Letter and grouped by the value of the GroupBy label.
+type browsePage struct {
+	Letter  string
+	GroupBy string
+	Page    int
+	Pages   int
+	Total   int
+	Letters []browseLetter
+	// LabelKeys are the labels used by the SwDocs, to group them by.
+	LabelKeys []string
+	Groups    []browseGroup
+}
+
+type browseLetter struct {
+	Letter string
+	Count  int
+}
+
+// browseGroup is a group of SwDocs of the page, Count is the size of the
+// whole group which can be split across pages.
+type browseGroup struct {
+	Title  string
+	Count  int
+	SwDocs []SwDoc
+}
+
+// letterOf is the index entry of the SwDoc called name.
+func letterOf(name string) string {
+	r, _ := utf8.DecodeRuneInString(name)
+	r = unicode.ToUpper(r)
+	if r < 'A' || r > 'Z' {
+		return otherLetter
+	}
+	return string(r)
+}
+
+// newBrowsePage builds the given page of the index from docs sorted by name.
+func newBrowsePage(docs []SwDoc, letter, groupBy string, page int) browsePage {
+	p := browsePage{Letter: letter, GroupBy: groupBy, Page: page}
+
+	counts := make(map[string]int)
+	keys := make(map[string]bool)
+	var listed []SwDoc
+	for _, doc := range docs {
+		l := letterOf(doc.Name)
+		counts[l]++
+		for k := range doc.Labels {
+			keys[k] = true
+		}
+		if letter == "" || l == letter {
+			listed = append(listed, doc)
+		}
+	}
+	for r := 'A'; r <= 'Z'; r++ {
+		p.Letters = append(p.Letters, browseLetter{Letter: string(r), Count: counts[string(r)]})
+	}
+	p.Letters = append(p.Letters, browseLetter{Letter: otherLetter, Count: counts[otherLetter]})
+	for k := range keys {
+		p.LabelKeys = append(p.LabelKeys, k)
+	}
+	sort.Strings(p.LabelKeys)
+
+	// Without a label, SwDocs are grouped by letter. SwDocs without the label
+	// go last.
+	titleOf := func(doc SwDoc) string {
+		if groupBy == "" {
+			return letterOf(doc.Name)
+		}
+		return doc.Labels[groupBy]
+	}
+	if groupBy != "" {
+		sort.SliceStable(listed, func(i, j int) bool {
+			a, b := titleOf(listed[i]), titleOf(listed[j])
+			if a == "" || b == "" {
+				return a != "" && b == ""
+			}
+			return a < b
+		})
+	}
+	groupCounts := make(map[string]int)
+	for _, doc := range listed {
+		groupCounts[titleOf(doc)]++
+	}
+
+	p.Total = len(listed)
+	p.Pages = (p.Total + browsePageSize - 1) / browsePageSize
+	start := (page - 1) * browsePageSize
+	if start >= p.Total {
+		return p
+	}
+	end := start + browsePageSize
+	if end > p.Total {
+		end = p.Total
+	}
+	for _, doc := range listed[start:end] {
+		title := titleOf(doc)
+		if n := len(p.Groups); n == 0 || p.Groups[n-1].Title != title {
+			p.Groups = append(p.Groups, browseGroup{Title: title, Count: groupCounts[title]})
+		}
+		p.Groups[len(p.Groups)-1].SwDocs = append(p.Groups[len(p.Groups)-1].SwDocs, doc)
+	}
+	for i := range p.Groups {
+		if p.Groups[i].Title == "" {
+			p.Groups[i].Title = "No " + groupBy
+		}
+	}
+	return p
+}
+
+// URL links to another letter, grouping or page of the index, an empty
+// letter or groupBy lists every SwDoc or doesn't group them by label.
+func (p browsePage) URL(letter, groupBy string, page int) string {
+	q := url.Values{}
+	if letter != "" {
+		q.Set("letter", letter)
+	}
+	if groupBy != "" {
+		q.Set("groupBy", groupBy)
+	}
+	if page > 1 {
+		q.Set("page", strconv.Itoa(page))
+	}
+	if len(q) == 0 {
+		return "/all"
+	}
+	return "/all?" + q.Encode()
+}
+
+// PrevURL and NextURL link to the pages around this one, they are empty on
+// the first and last pages.
+func (p browsePage) PrevURL() string {
+	if p.Page <= 1 {
+		return ""
+	}
+	return p.URL(p.Letter, p.GroupBy, p.Page-1)
+}
+
+func (p browsePage) NextURL() string {
+	if p.Page >= p.Pages {
+		return ""
+	}
+	return p.URL(p.Letter, p.GroupBy, p.Page+1)
+}
+
+// parseLetter checks ?letter is an entry of the index.
+func parseLetter(v string) (string, bool) {
+	if v == "" || v == otherLetter {
+		return v, true
+	}
+	v = strings.ToUpper(v)
+	if len(v) != 1 || v[0] < 'A' || v[0] > 'Z' {
+		return "", false
+	}
+	return v, true
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andrecp/swdocs"
+	log "github.com/sirupsen/logrus"
+)
+
+// Status of a link in the check report.
+const (
+	linkOk      = "ok"
+	linkBroken  = "broken"
+	linkSkipped = "skipped"
+)
+
+type checkReport struct {
+	Passed bool               `json:"passed"`
+	SwDocs []swDocCheckReport `json:"swdocs"`
+}
+
+type swDocCheckReport struct {
+	Name             string                  `json:"name"`
+	File             string                  `json:"file"`
+	ValidationErrors swdocs.ValidationErrors `json:"validationErrors,omitempty"`
+	Links            []linkCheckReport       `json:"links"`
+}
+
+type linkCheckReport struct {
+	Section     string `json:"section"`
+	Description string `json:"description"`
+	URL         string `json:"url"`
+	Status      string `json:"status"`
+	StatusCode  int    `json:"statusCode,omitempty"`
+	Error       string `json:"error,omitempty"`
+	LatencyMs   int64  `json:"latencyMs"`
+	Attempts    int    `json:"attempts"`
+}
+
+// runCheck validates SwDoc files and checks their links, exiting with 1 if
+// anything is wrong. It doesn't talk to the swdocs server, so it runs in CI.
+func runCheck(args []string) {
+	cmd := flag.NewFlagSet("check", flag.ExitOnError)
+	recursiveFlag := cmd.Bool("recursive", false, "Also check the SwDocs in sub directories of a directory")
+	timeoutFlag := cmd.Duration("timeout", 10*time.Second, "Time to wait for each link to answer")
+	retriesFlag := cmd.Int("retries", 2, "How many times to retry a broken link before reporting it")
+	allowFlag := cmd.String("allow-host", "", "Comma separated hosts which aren't checked, e.g. internal hosts unreachable from CI. *.example.com matches its subdomains")
+	formatFlag := cmd.String("format", "json", "The format of the report, options are 'json' and 'junit'")
+	outputFlag := cmd.String("output", "", "Write the report to this file instead of the standard output")
+
+	if err := parseArgs(cmd, args); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	path := cmd.Arg(0)
+	if path == "" {
+		fmt.Println("A path to a JSON or YAML file, or to a directory, is required to check.")
+		os.Exit(2)
+	}
+	if *formatFlag != "json" && *formatFlag != "junit" {
+		fmt.Println("Unsupported format, options are 'json' and 'junit'")
+		os.Exit(2)
+	}
+
+	files, err := readSwDocFiles(path, *recursiveFlag)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	var allowedHosts []string
+	for _, host := range strings.Split(*allowFlag, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			allowedHosts = append(allowedHosts, strings.ToLower(host))
+		}
+	}
+
+	report := checkSwDocs(files, &http.Client{Timeout: *timeoutFlag}, allowedHosts, *retriesFlag)
+
+	var out []byte
+	if *formatFlag == "junit" {
+		out, err = junitReport(report)
+	} else {
+		out, err = json.MarshalIndent(report, "", "  ")
+	}
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	if *outputFlag != "" {
+		if err := ioutil.WriteFile(*outputFlag, out, 0644); err != nil {
+			log.Fatal(err.Error())
+		}
+	} else {
+		fmt.Println(string(out))
+	}
+
+	if !report.Passed {
+		os.Exit(1)
+	}
+}
+
+// checkSwDocs validates the SwDocs read from files and checks their links.
+// A name found more than once, in one file or across files, fails the check
+// as applying them would overwrite each other.
+func checkSwDocs(files []swDocFile, client *http.Client, allowedHosts []string, retries int) checkReport {
+	paths := make(map[string][]string)
+	for _, f := range files {
+		paths[f.SwDoc.Name] = append(paths[f.SwDoc.Name], f.Path)
+	}
+
+	report := checkReport{Passed: true}
+	for _, f := range files {
+		doc := f.SwDoc
+		docReport := swDocCheckReport{Name: doc.Name, File: f.Path, Links: []linkCheckReport{}}
+		if err := doc.Validate(); err != nil {
+			docReport.ValidationErrors = err.(swdocs.ValidationErrors)
+			report.Passed = false
+		}
+		if found := paths[doc.Name]; doc.Name != "" && len(found) > 1 {
+			docReport.ValidationErrors = append(docReport.ValidationErrors, swdocs.ValidationError{
+				Field:   "name",
+				Message: fmt.Sprintf("%s is defined %d times, in %s", doc.Name, len(found), strings.Join(found, ", ")),
+			})
+			report.Passed = false
+		}
+
+		for _, section := range doc.Sections {
+			for _, link := range section.Links {
+				docReport.Links = append(docReport.Links, linkCheckReport{
+					Section:     section.Header,
+					Description: link.Description,
+					URL:         link.URL,
+				})
+			}
+		}
+
+		var wg sync.WaitGroup
+		semaphore := make(chan struct{}, 4)
+		for i := range docReport.Links {
+			semaphore <- struct{}{}
+			wg.Add(1)
+			go func(l *linkCheckReport) {
+				defer func() {
+					<-semaphore
+					wg.Done()
+				}()
+				checkReportLink(client, l, allowedHosts, retries)
+			}(&docReport.Links[i])
+		}
+		wg.Wait()
+
+		for _, l := range docReport.Links {
+			if l.Status == linkBroken {
+				report.Passed = false
+			}
+		}
+		report.SwDocs = append(report.SwDocs, docReport)
+	}
+	return report
+}
+
+// checkReportLink checks a link, retrying with a growing pause while it is broken.
+func checkReportLink(client *http.Client, l *linkCheckReport, allowedHosts []string, retries int) {
+	u, err := url.Parse(l.URL)
+	if err != nil {
+		l.Status = linkBroken
+		l.Error = err.Error()
+		return
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || hostAllowed(u.Hostname(), allowedHosts) {
+		l.Status = linkSkipped
+		return
+	}
+
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		status := swdocs.CheckLink(client, l.URL)
+		l.Attempts = attempt + 1
+		l.StatusCode = status.StatusCode
+		l.Error = status.Error
+		l.LatencyMs = status.LatencyMs
+		if !status.Broken() {
+			l.Status = linkOk
+			return
+		}
+	}
+	l.Status = linkBroken
+}
+
+func hostAllowed(host string, allowedHosts []string) bool {
+	host = strings.ToLower(host)
+	for _, allowed := range allowedHosts {
+		if host == allowed {
+			return true
+		}
+		if strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// junitReport has a test suite per SwDoc with a test case for its validation and one per link.
+func junitReport(report checkReport) ([]byte, error) {
+	suites := junitTestSuites{}
+	for _, doc := range report.SwDocs {
+		suite := junitTestSuite{Name: doc.Name}
+
+		validation := junitTestCase{ClassName: doc.Name, Name: "validation", Time: "0"}
+		if len(doc.ValidationErrors) > 0 {
+			validation.Failure = &junitFailure{Message: "invalid SwDoc", Text: doc.ValidationErrors.Error()}
+		}
+		suite.Cases = append(suite.Cases, validation)
+
+		for _, l := range doc.Links {
+			tc := junitTestCase{
+				ClassName: doc.Name + "." + l.Section,
+				Name:      l.URL,
+				Time:      fmt.Sprintf("%.3f", float64(l.LatencyMs)/1000),
+			}
+			switch l.Status {
+			case linkBroken:
+				message := l.Error
+				if message == "" {
+					message = fmt.Sprintf("status code %d", l.StatusCode)
+				}
+				tc.Failure = &junitFailure{Message: message, Text: fmt.Sprintf("%s (%s) is broken after %d attempts: %s", l.Description, l.URL, l.Attempts, message)}
+			case linkSkipped:
+				tc.Skipped = &struct{}{}
+			}
+			suite.Cases = append(suite.Cases, tc)
+		}
+
+		for _, tc := range suite.Cases {
+			suite.Tests++
+			if tc.Failure != nil {
+				suite.Failures++
+			}
+			if tc.Skipped != nil {
+				suite.Skipped++
+			}
+		}
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Suites = append(suites.Suites, suite)
+	}
+
+	out, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, dir, name, contents string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckSwDocs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	writeTestFile(t, dir, "rabbitmq.json", `{"name": "rabbitmq", "description": "A broker", "user": "test",
+		"sections": [{"header": "Links", "links": [
+			{"url": "`+server.URL+`/docs", "description": "Docs"},
+			{"url": "`+server.URL+`/gone", "description": "Old docs"},
+			{"url": "https://grafana.internal/d/rabbitmq", "description": "Dashboard"}
+		]}]}`)
+	writeTestFile(t, dir, "brokers.yaml", "name: rabbitmq\ndescription: Another broker\nuser: test\n---\nname: kafka\ndescription: A log\nuser: test\n")
+
+	files, err := readSwDocFiles(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := checkSwDocs(files, &http.Client{Timeout: time.Second}, []string{"grafana.internal"}, 0)
+	if report.Passed {
+		t.Error("got the check passing, want it to fail")
+	}
+
+	byFile := make(map[string]swDocCheckReport)
+	for _, doc := range report.SwDocs {
+		byFile[filepath.Base(doc.File)+":"+doc.Name] = doc
+	}
+	if len(byFile) != 3 {
+		t.Fatalf("got %d SwDocs in the report, want 3", len(byFile))
+	}
+
+	for _, key := range []string{"rabbitmq.json:rabbitmq", "brokers.yaml:rabbitmq"} {
+		errs := byFile[key].ValidationErrors
+		if len(errs) != 1 || errs[0].Field != "name" || !strings.Contains(errs[0].Message, "rabbitmq.json") || !strings.Contains(errs[0].Message, "brokers.yaml") {
+			t.Errorf("%s: got errors %v, want rabbitmq to be reported in both files", key, errs)
+		}
+	}
+	if errs := byFile["brokers.yaml:kafka"].ValidationErrors; len(errs) != 0 {
+		t.Errorf("kafka: got errors %v, want none", errs)
+	}
+
+	want := map[string]string{
+		server.URL + "/docs":                  linkOk,
+		server.URL + "/gone":                  linkBroken,
+		"https://grafana.internal/d/rabbitmq": linkSkipped,
+	}
+	links := byFile["rabbitmq.json:rabbitmq"].Links
+	if len(links) != len(want) {
+		t.Fatalf("got %d links, want %d", len(links), len(want))
+	}
+	for _, l := range links {
+		if l.Status != want[l.URL] {
+			t.Errorf("%s: got status %q, want %q", l.URL, l.Status, want[l.URL])
+		}
+	}
+}
+
+func TestCheckSwDocsPasses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	writeTestFile(t, dir, "rabbitmq.json", `{"name": "rabbitmq", "description": "A broker", "user": "test",
+		"sections": [{"header": "Links", "links": [{"url": "`+server.URL+`", "description": "Docs"}]}]}`)
+	writeTestFile(t, dir, "kafka.json", `{"name": "kafka", "description": "A log", "user": "test"}`)
+
+	files, err := readSwDocFiles(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := checkSwDocs(files, &http.Client{Timeout: time.Second}, nil, 0); !report.Passed {
+		t.Errorf("got the check failing: %+v", report)
+	}
+}
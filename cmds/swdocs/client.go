@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// sendJSON sends payload, encoded as JSON unless nil, to the swdocs server
+// and returns the response along with its already read body.
+func sendJSON(method, url string, payload interface{}, header http.Header) (*http.Response, []byte, error) {
+	var reqBody io.Reader
+	if payload != nil {
+		requestBody, err := json.Marshal(payload)
+		if err != nil {
+			return nil, nil, err
+		}
+		reqBody = bytes.NewBuffer(requestBody)
+	}
+
+	req, err := http.NewRequest(method, url, reqBody)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if payload != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, body, nil
+}
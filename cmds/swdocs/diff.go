@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/andrecp/swdocs"
+	log "github.com/sirupsen/logrus"
+)
+
+// runDiff shows what applying the SwDocs in a file or directory would change,
+// it exits with 1 when there are changes like kubectl diff.
+func runDiff(args []string, baseURL string) {
+	cmd := flag.NewFlagSet("diff", flag.ExitOnError)
+	userFlag := cmd.String("user", "", "Override the user, useful for CI")
+	recursiveFlag := cmd.Bool("recursive", false, "Also diff the SwDocs in sub directories of a directory")
+
+	if err := parseArgs(cmd, args); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	path := cmd.Arg(0)
+	if path == "" {
+		fmt.Println("A path to a JSON or YAML file, or to a directory, is required to diff.")
+		os.Exit(1)
+	}
+
+	username, err := currentUser(*userFlag)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	docs, err := readSwDocs(path, *recursiveFlag)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	for i := range docs {
+		docs[i].User = username
+	}
+
+	results := dryRunApply(baseURL, docs)
+	printApplyResults(results, true)
+
+	for _, result := range results {
+		if result.Action != swdocs.ActionUnchanged {
+			os.Exit(1)
+		}
+	}
+}
+
+// dryRunApply asks the server what applying docs would change, exiting on errors.
+func dryRunApply(baseURL string, docs []swdocs.SwDoc) []swdocs.ApplyResult {
+	resp, body, err := sendJSON("POST", baseURL+"/api/v1/swdocs/batch?dryRun=true", docs, nil)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	results := []swdocs.ApplyResult{}
+	if err := json.Unmarshal(body, &results); err != nil {
+		fmt.Println(string(body))
+		os.Exit(2)
+	}
+	if resp.StatusCode != http.StatusOK {
+		printApplyResults(results, false)
+		os.Exit(2)
+	}
+	return results
+}
+
+// printApplyResults prints one line per SwDoc and, if showChanges, its changed fields.
+func printApplyResults(results []swdocs.ApplyResult, showChanges bool) {
+	for _, result := range results {
+		if result.Error != "" {
+			fmt.Println("! " + result.Name + " -> error: " + result.Error)
+			continue
+		}
+		if result.Action == "" {
+			continue
+		}
+
+		symbol := map[string]string{
+			swdocs.ActionCreated:   "+",
+			swdocs.ActionUpdated:   "~",
+			swdocs.ActionUnchanged: "=",
+		}[result.Action]
+		fmt.Println(symbol + " " + result.Name + " -> " + result.Action)
+
+		if !showChanges {
+			continue
+		}
+		for _, change := range result.Changes {
+			switch {
+			case change.Old == nil:
+				fmt.Println("    + " + change.Field + ": " + formatValue(change.New))
+			case change.New == nil:
+				fmt.Println("    - " + change.Field + ": " + formatValue(change.Old))
+			default:
+				fmt.Println("    ~ " + change.Field + ": " + formatValue(change.Old) + " -> " + formatValue(change.New))
+			}
+		}
+	}
+}
+
+func formatValue(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
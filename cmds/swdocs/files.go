@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andrecp/swdocs"
+	"gopkg.in/yaml.v3"
+)
+
+// swDocFile is a SwDoc along with the file it was read from.
+type swDocFile struct {
+	Path  string
+	SwDoc swdocs.SwDoc
+}
+
+// readSwDocs reads every SwDoc found in path, which can be a file or a directory.
+// Directories are only walked into when recursive is set.
+func readSwDocs(path string, recursive bool) ([]swdocs.SwDoc, error) {
+	files, err := readSwDocFiles(path, recursive)
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]swdocs.SwDoc, len(files))
+	for i, f := range files {
+		docs[i] = f.SwDoc
+	}
+	return docs, nil
+}
+
+// readSwDocFiles is readSwDocs keeping track of the file of every SwDoc.
+func readSwDocFiles(path string, recursive bool) ([]swDocFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []swDocFile
+	read := func(p string) error {
+		docs, err := readSwDocsFile(p)
+		for _, doc := range docs {
+			files = append(files, swDocFile{Path: p, SwDoc: doc})
+		}
+		return err
+	}
+
+	if !info.IsDir() {
+		return files, read(path)
+	}
+
+	err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if p != path && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(p)) {
+		case ".json", ".yaml", ".yml":
+			return read(p)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// readSwDocsFile reads a JSON file with a SwDoc or a list of SwDocs, or a
+// YAML file with one or more SwDocs separated by ---.
+func readSwDocsFile(path string) ([]swdocs.SwDoc, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []swdocs.SwDoc
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		docs, err = decodeYAMLSwDocs(contents)
+	default:
+		docs, err = decodeJSONSwDocs(contents)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return docs, nil
+}
+
+func decodeJSONSwDocs(contents []byte) ([]swdocs.SwDoc, error) {
+	var docs []swdocs.SwDoc
+	trimmed := bytes.TrimSpace(contents)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		err := json.Unmarshal(trimmed, &docs)
+		return docs, err
+	}
+
+	var doc swdocs.SwDoc
+	if err := json.Unmarshal(trimmed, &doc); err != nil {
+		return nil, err
+	}
+	return append(docs, doc), nil
+}
+
+func decodeYAMLSwDocs(contents []byte) ([]swdocs.SwDoc, error) {
+	var docs []swdocs.SwDoc
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	for {
+		var v interface{}
+		err := decoder.Decode(&v)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			continue
+		}
+
+		// Go through JSON so the SwDoc json tags are used for the field names.
+		jsonText, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		fileDocs, err := decodeJSONSwDocs(jsonText)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, fileDocs...)
+	}
+	return docs, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/andrecp/swdocs"
+	log "github.com/sirupsen/logrus"
+)
+
+const linkHelp = `Missing or unsupported link subcommand! You can use:
+  * swdocs link add mysoftware --section Dashboards --url http://grafana/d/1 --description "Main dashboard" [--slug dashboard]
+  * swdocs link rm mysoftware --section Dashboards --url http://grafana/d/1
+`
+
+type patchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// runLink adds or removes a single link of a SwDoc with a JSON Patch, the
+// patch tests the section it changes and is conditional to the revision read.
+func runLink(args []string, baseURL string) {
+	if len(args) < 1 || (args[0] != "add" && args[0] != "rm") {
+		fmt.Print(linkHelp)
+		os.Exit(1)
+	}
+	subCmd := args[0]
+
+	cmd := flag.NewFlagSet("link "+subCmd, flag.ExitOnError)
+	sectionFlag := cmd.String("section", "", "The header of the section of the link")
+	urlFlag := cmd.String("url", "", "The URL of the link")
+	userFlag := cmd.String("user", "", "Override the user, useful for CI")
+	var descriptionFlag, slugFlag *string
+	if subCmd == "add" {
+		descriptionFlag = cmd.String("description", "", "The description of the link")
+		slugFlag = cmd.String("slug", "", "Short name of the link, /mysoftware/slug redirects to it")
+	}
+
+	if err := parseArgs(cmd, args[1:]); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	name := cmd.Arg(0)
+	if name == "" || *sectionFlag == "" || *urlFlag == "" {
+		fmt.Println("A SwDoc name, --section and --url are required.")
+		os.Exit(1)
+	}
+
+	username, err := currentUser(*userFlag)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	docURL := baseURL + "/api/v1/swdocs/" + url.PathEscape(name)
+	resp, body, err := sendJSON("GET", docURL, nil, nil)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		fmt.Println(string(body))
+		os.Exit(1)
+	}
+
+	doc := swdocs.SwDoc{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		log.Fatal(err.Error())
+	}
+	// The name may be an alias or an old name, patch the SwDoc it led to.
+	docURL = baseURL + "/api/v1/swdocs/" + url.PathEscape(doc.Name)
+
+	sectionIdx := -1
+	for i, section := range doc.Sections {
+		if section.Header == *sectionFlag {
+			sectionIdx = i
+			break
+		}
+	}
+
+	var ops []patchOp
+	if subCmd == "add" {
+		newLink := map[string]string{"url": *urlFlag, "description": *descriptionFlag}
+		if *slugFlag != "" {
+			newLink["slug"] = *slugFlag
+		}
+		if sectionIdx == -1 {
+			ops = append(ops, patchOp{Op: "add", Path: "/sections/-", Value: map[string]interface{}{
+				"header": *sectionFlag,
+				"links":  []interface{}{newLink},
+			}})
+		} else {
+			sectionPath := fmt.Sprintf("/sections/%d", sectionIdx)
+			ops = append(ops,
+				patchOp{Op: "test", Path: sectionPath + "/header", Value: *sectionFlag},
+				patchOp{Op: "add", Path: sectionPath + "/links/-", Value: newLink},
+			)
+		}
+	} else {
+		linkIdx := -1
+		if sectionIdx != -1 {
+			for i, link := range doc.Sections[sectionIdx].Links {
+				if link.URL == *urlFlag {
+					linkIdx = i
+					break
+				}
+			}
+		}
+		if linkIdx == -1 {
+			fmt.Printf("%s has no link to %s in the section %q\n", name, *urlFlag, *sectionFlag)
+			os.Exit(1)
+		}
+		linkPath := fmt.Sprintf("/sections/%d/links/%d", sectionIdx, linkIdx)
+		ops = append(ops,
+			patchOp{Op: "test", Path: linkPath + "/url", Value: *urlFlag},
+			patchOp{Op: "remove", Path: linkPath},
+		)
+	}
+	ops = append(ops, patchOp{Op: "add", Path: "/user", Value: username})
+
+	header := http.Header{}
+	header.Set("Content-Type", swdocs.JSONPatchContentType)
+	header.Set("If-Match", resp.Header.Get("ETag"))
+	resp, body, err = sendJSON("PATCH", docURL, ops, header)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		fmt.Println("Ok.")
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		fmt.Println(name + " was changed while updating it, try again.")
+		os.Exit(1)
+	default:
+		fmt.Println(string(body))
+		os.Exit(1)
+	}
+}
@@ -1,15 +1,21 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"os/user"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	// The timezone database is embedded so --tz and SWDOCS_DEFAULT_TZ work on
+	// systems without one.
+	_ "time/tzdata"
 
 	"github.com/andrecp/swdocs"
 
@@ -23,14 +29,39 @@ const (
 	defaultTemplatesPath = "."
 	defaultDbPath        = "swdev.sqlite"
 	defaultLogLevel      = log.WarnLevel
+	defaultTimezone      = "UTC"
+	// Links are checked every 6 hours, at most 4 at a time and once a second per host.
+	defaultLinkCheckInterval    = "6h"
+	defaultLinkCheckConcurrency = 4
+	defaultLinkCheckHostDelay   = "1s"
+	defaultLinkCheckTimeout     = "10s"
+	// SwDocs not updated for a year are stale.
+	defaultStaleDays = 365
+	// Failed webhook deliveries are retried 5 times, after 1s, 2s, 4s, 8s and 16s.
+	defaultWebhookRetries = 5
+	defaultWebhookBackoff = "1s"
+	defaultWebhookTimeout = "10s"
+	// Deleted SwDocs can be restored for 30 days.
+	defaultTrashDays = 30
 
 	// Other constants
 	subCommandHelp = `Missing or unsupported subcommand! You can use:
   * swdocs bootstrap mysoftware    # Creates a mysoftware.json to be modified and used with apply
   * swdocs apply mysoftware.json   # To create or update a swdoc for mysoftware
+  * swdocs apply -f docs/ --recursive # To create or update every swdoc in a directory
+  * swdocs diff mysoftware.json    # To see what applying mysoftware.json would change
+  * swdocs check mysoftware.json   # To validate mysoftware.json and check its links, useful for CI
   * swdocs get mysoftware          # To get info about a swdoc called mysoftware
-  * swdocs delete mysoftware       # To delete a swdoc called mysoftware
-  * swdocs list                    # To list available swdocs, use --filter to filter.
+  * swdocs link add mysoftware --section Dashboards --url URL --description D # To add a link to mysoftware
+  * swdocs link rm mysoftware --section Dashboards --url URL # To remove a link from mysoftware
+  * swdocs rename mysoftware newsoftware # To rename mysoftware, the old name redirects to the new one
+  * swdocs delete mysoftware       # To move a swdoc called mysoftware to the trash
+  * swdocs trash restore mysoftware # To restore a deleted swdoc, see swdocs trash list
+  * swdocs list                    # To list available swdocs, use --filter and --selector to filter.
+  * swdocs sync docs/ --prune --selector managed-by=docs-repo # To make the server match a directory
+  * swdocs report stale --days 180 # To list the swdocs nobody updated lately, grouped by owner
+  * swdocs webhook add --url URL   # To POST an event to URL whenever a swdoc changes
+  * swdocs watch                   # To print the changes to swdocs as they happen
   * swdocs serve                   # To run the swdoc server
 
 Every subcommand supports --help.
@@ -58,6 +89,57 @@ func init() {
 
 }
 
+// parseArgs parses the flags of a subcommand allowing them to come before or
+// after its positional arguments, e.g. "swdocs apply foo.json --user ken".
+func parseArgs(cmd *flag.FlagSet, args []string) error {
+	var positional []string
+	for {
+		if err := cmd.Parse(args); err != nil {
+			return err
+		}
+		args = cmd.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	return cmd.Parse(append([]string{"--"}, positional...))
+}
+
+// quoteETag accepts revisions given with or without the ETag quotes.
+func quoteETag(v string) string {
+	if v == "*" || strings.HasPrefix(v, "\"") || strings.HasPrefix(v, "W/") {
+		return v
+	}
+	return "\"" + v + "\""
+}
+
+// envDuration reads a duration like "1h30m" from an environment variable.
+func envDuration(name, defaultValue string) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		v = defaultValue
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatal("Invalid " + name + ": " + err.Error())
+	}
+	return d
+}
+
+// currentUser is the owner of the process unless override is given.
+func currentUser(override string) (string, error) {
+	if override != "" {
+		return override, nil
+	}
+	user, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return user.Username, nil
+}
+
 func main() {
 
 	// Declare command line subcommands and options.
@@ -65,14 +147,23 @@ func main() {
 
 	getCmd := flag.NewFlagSet("get", flag.ExitOnError)
 	fmtGetCmd := getCmd.String("format", "human", "The format of the output, options are 'json' and 'human'")
+	tzGetCmd := getCmd.String("tz", "", "The timezone of the dates, like Europe/Paris, defaults to the local one")
 
 	applyCmd := flag.NewFlagSet("apply", flag.ExitOnError)
 	userApplyCmd := applyCmd.String("user", "", "Override the user, useful for CI")
+	fileApplyCmd := applyCmd.String("file", "", "A JSON or YAML file, or a directory, with the SwDocs to apply")
+	applyCmd.StringVar(fileApplyCmd, "f", "", "Shorthand for --file")
+	ifMatchApplyCmd := applyCmd.String("if-match", "", "Only apply if the SwDoc is still at this revision (ETag)")
+	dryRunApplyCmd := applyCmd.Bool("dry-run", false, "Show what would change without applying anything")
+	recursiveApplyCmd := applyCmd.Bool("recursive", false, "Also apply the SwDocs in sub directories of a --file directory")
 
 	listCmd := flag.NewFlagSet("list", flag.ExitOnError)
 	filterListCmd := listCmd.String("filter", "%", "Filter by name, % is a wildcard.")
+	selectorListCmd := listCmd.String("selector", "", "Filter by labels, e.g. team=payments,tier!=3")
 
 	deleteCmd := flag.NewFlagSet("delete", flag.ExitOnError)
+	ifMatchDeleteCmd := deleteCmd.String("if-match", "", "Only delete if the SwDoc is still at this revision (ETag)")
+	userDeleteCmd := deleteCmd.String("user", "", "Override the user, useful for CI")
 
 	serveCmd := flag.NewFlagSet("serve", flag.ExitOnError)
 
@@ -97,8 +188,7 @@ func main() {
 	// Call the right subcommand.
 	switch os.Args[1] {
 	case "bootstrap":
-		bootstrapCmd.Parse(os.Args[2:])
-		err := bootstrapCmd.Parse(os.Args[2:])
+		err := parseArgs(bootstrapCmd, os.Args[2:])
 		if err != nil {
 			log.Fatal(err.Error())
 		}
@@ -131,8 +221,7 @@ func main() {
 		fmt.Println(fileName + " created.")
 
 	case "get":
-		getCmd.Parse(os.Args[2:])
-		err := getCmd.Parse(os.Args[2:])
+		err := parseArgs(getCmd, os.Args[2:])
 		if err != nil {
 			log.Fatal(err.Error())
 		}
@@ -166,15 +255,46 @@ func main() {
 		}
 
 		if *fmtGetCmd == "human" {
+			loc := time.Local
+			if *tzGetCmd != "" {
+				loc, err = time.LoadLocation(*tzGetCmd)
+				if err != nil {
+					fmt.Println("Unknown timezone " + *tzGetCmd + ", use a name like Europe/Paris")
+					os.Exit(1)
+				}
+			}
 			fmt.Println("Name: " + string(r.Name))
-			fmt.Println("Description: " + string(r.Description))
+			fmt.Println("Description: " + swdocs.MarkdownToText(r.Description))
 			fmt.Println("Last updated by: " + string(r.User))
-			fmt.Println("Last updated on: " + r.Updated.ToString())
+			fmt.Println("Last updated on: " + r.Updated.Format(loc) + " (" + r.Updated.Ago() + ")")
+			fmt.Println("Revision: " + strconv.FormatInt(r.Revision, 10))
+			if len(r.Labels) > 0 {
+				var labels []string
+				for k, v := range r.Labels {
+					labels = append(labels, k+"="+v)
+				}
+				sort.Strings(labels)
+				fmt.Println("Labels: " + strings.Join(labels, ", "))
+			}
+			if len(r.Related) > 0 {
+				var related []string
+				for _, rel := range r.Related {
+					related = append(related, rel.Type+" "+rel.Name)
+				}
+				fmt.Println("Related: " + strings.Join(related, ", "))
+			}
 			fmt.Println("")
 			for _, section := range r.Sections {
 				fmt.Println(section.Header)
+				if section.Description != "" {
+					fmt.Println(swdocs.MarkdownToText(section.Description))
+				}
 				for _, link := range section.Links {
-					fmt.Println(" * " + link.Description + " (" + link.URL + ")")
+					if link.Slug != "" {
+						fmt.Println(" * " + link.Description + " (" + link.URL + ") -> " + baseURL + "/" + r.Name + "/" + link.Slug)
+					} else {
+						fmt.Println(" * " + link.Description + " (" + link.URL + ")")
+					}
 				}
 
 			}
@@ -190,63 +310,83 @@ func main() {
 		}
 
 	case "apply":
-		applyCmd.Parse(os.Args[2:])
-		err := applyCmd.Parse(os.Args[2:])
+		err := parseArgs(applyCmd, os.Args[2:])
 		if err != nil {
 			log.Fatal(err.Error())
 		}
 
-		applyFilePath := applyCmd.Arg(0)
-		if applyFilePath == "" {
-			fmt.Println("A file path to a JSON is required as an argument to be apply.")
-			os.Exit(1)
+		applyPath := *fileApplyCmd
+		if applyPath == "" {
+			applyPath = applyCmd.Arg(0)
 		}
-
-		var username string
-		if *userApplyCmd == "" {
-			user, err := user.Current()
-			if err != nil {
-				log.Fatal(err.Error())
-			}
-			username = user.Username
-		} else {
-			username = *userApplyCmd
+		if applyPath == "" {
+			fmt.Println("A path to a JSON or YAML file, or to a directory, is required to apply.")
+			os.Exit(1)
 		}
 
-		jsonText, err := ioutil.ReadFile(applyFilePath)
+		username, err := currentUser(*userApplyCmd)
 		if err != nil {
 			log.Fatal(err.Error())
 		}
 
-		r := swdocs.SwDoc{}
-		err = json.Unmarshal(jsonText, &r)
+		docs, err := readSwDocs(applyPath, *recursiveApplyCmd)
 		if err != nil {
 			log.Fatal(err.Error())
 		}
+		if len(docs) == 0 {
+			fmt.Println("No SwDocs found in " + applyPath)
+			os.Exit(1)
+		}
 
-		r.User = username
+		for i := range docs {
+			docs[i].User = username
+		}
 
-		requestBody, err := json.Marshal(r)
-		if err != nil {
-			log.Fatal(err.Error())
+		if *dryRunApplyCmd {
+			printApplyResults(dryRunApply(baseURL, docs), true)
+			break
 		}
 
-		resp, err := http.Post(baseURL+"/api/v1/swdocs/apply", "application/json", bytes.NewBuffer(requestBody))
-		if err != nil {
-			log.Fatal(err.Error())
+		// A single SwDoc goes through apply, many are applied together in a batch.
+		if len(docs) == 1 {
+			header := http.Header{}
+			if *ifMatchApplyCmd != "" {
+				header.Set("If-Match", quoteETag(*ifMatchApplyCmd))
+			}
+			resp, body, err := sendJSON("POST", baseURL+"/api/v1/swdocs/apply", docs[0], header)
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+			fmt.Println(string(body))
+			if resp.StatusCode != http.StatusCreated {
+				os.Exit(1)
+			}
+			break
 		}
 
-		defer resp.Body.Close()
-		body, err := ioutil.ReadAll(resp.Body)
+		if *ifMatchApplyCmd != "" {
+			fmt.Println("--if-match can only be used when applying a single SwDoc.")
+			os.Exit(1)
+		}
+
+		resp, body, err := sendJSON("POST", baseURL+"/api/v1/swdocs/batch", docs, nil)
 		if err != nil {
 			log.Fatal(err.Error())
 		}
 
-		fmt.Println(string(body))
+		results := []swdocs.ApplyResult{}
+		if err := json.Unmarshal(body, &results); err != nil {
+			fmt.Println(string(body))
+			os.Exit(1)
+		}
+		printApplyResults(results, false)
+		if resp.StatusCode != http.StatusOK {
+			fmt.Println("Nothing was applied.")
+			os.Exit(1)
+		}
 
 	case "list":
-		listCmd.Parse(os.Args[2:])
-		err := listCmd.Parse(os.Args[2:])
+		err := parseArgs(listCmd, os.Args[2:])
 		if err != nil {
 			log.Fatal(err.Error())
 		}
@@ -259,9 +399,15 @@ func main() {
 
 		q := req.URL.Query()
 		q.Add("filter", *filterListCmd)
+		if *selectorListCmd != "" {
+			q.Add("selector", *selectorListCmd)
+		}
 		req.URL.RawQuery = q.Encode()
 
 		resp, err := client.Do(req)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
 		defer resp.Body.Close()
 
 		body, err := ioutil.ReadAll(resp.Body)
@@ -269,6 +415,11 @@ func main() {
 			log.Fatal(err.Error())
 		}
 
+		if resp.StatusCode != http.StatusOK {
+			fmt.Println(string(body))
+			os.Exit(1)
+		}
+
 		r := []swdocs.SwDoc{}
 		err = json.Unmarshal(body, &r)
 		if err != nil {
@@ -279,7 +430,7 @@ func main() {
 		}
 
 	case "delete":
-		err := deleteCmd.Parse(os.Args[2:])
+		err := parseArgs(deleteCmd, os.Args[2:])
 		if err != nil {
 			log.Fatal(err.Error())
 		}
@@ -290,11 +441,19 @@ func main() {
 			os.Exit(1)
 		}
 
+		username, err := currentUser(*userDeleteCmd)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+
 		client := &http.Client{}
-		req, err := http.NewRequest("DELETE", baseURL+"/api/v1/swdocs/"+name, nil)
+		req, err := http.NewRequest("DELETE", baseURL+"/api/v1/swdocs/"+name+"?user="+url.QueryEscape(username), nil)
 		if err != nil {
 			log.Fatal(err.Error())
 		}
+		if *ifMatchDeleteCmd != "" {
+			req.Header.Set("If-Match", quoteETag(*ifMatchDeleteCmd))
+		}
 
 		// Fetch Request
 		resp, err := client.Do(req)
@@ -302,11 +461,42 @@ func main() {
 			log.Fatal(err.Error())
 		}
 
+		if resp.StatusCode == http.StatusPreconditionFailed {
+			fmt.Println("Not deleted, the SwDoc was changed since the revision given in --if-match.")
+			os.Exit(1)
+		}
 		if resp.StatusCode != 200 {
 			fmt.Println("Something went wrong, check server logs.")
 			os.Exit(1)
 		}
-		fmt.Println("Ok.")
+		fmt.Println("Ok, " + name + " was moved to the trash, see swdocs trash restore.")
+
+	case "rename":
+		runRename(os.Args[2:], baseURL)
+
+	case "link":
+		runLink(os.Args[2:], baseURL)
+
+	case "check":
+		runCheck(os.Args[2:])
+
+	case "diff":
+		runDiff(os.Args[2:], baseURL)
+
+	case "sync":
+		runSync(os.Args[2:], baseURL)
+
+	case "report":
+		runReport(os.Args[2:], baseURL)
+
+	case "webhook":
+		runWebhook(os.Args[2:], baseURL)
+
+	case "watch":
+		runWatch(os.Args[2:], baseURL)
+
+	case "trash":
+		runTrash(os.Args[2:], baseURL)
 
 	case "serve":
 		serveCmd.Parse(os.Args[2:])
@@ -321,11 +511,83 @@ func main() {
 			templatesPath = defaultTemplatesPath
 		}
 
+		linkCheckInterval := envDuration("SWDOCS_LINKCHECK_INTERVAL", defaultLinkCheckInterval)
+		linkCheckHostDelay := envDuration("SWDOCS_LINKCHECK_HOST_DELAY", defaultLinkCheckHostDelay)
+		linkCheckTimeout := envDuration("SWDOCS_LINKCHECK_TIMEOUT", defaultLinkCheckTimeout)
+		linkCheckPrivate := false
+		if v := os.Getenv("SWDOCS_LINKCHECK_PRIVATE"); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				log.Fatal("Invalid SWDOCS_LINKCHECK_PRIVATE: " + err.Error())
+			}
+			linkCheckPrivate = b
+		}
+		linkCheckConcurrency := defaultLinkCheckConcurrency
+		if v := os.Getenv("SWDOCS_LINKCHECK_CONCURRENCY"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				log.Fatal("Invalid SWDOCS_LINKCHECK_CONCURRENCY: " + err.Error())
+			}
+			linkCheckConcurrency = n
+		}
+		staleDays := defaultStaleDays
+		if v := os.Getenv("SWDOCS_STALE_DAYS"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				log.Fatal("Invalid SWDOCS_STALE_DAYS: " + err.Error())
+			}
+			staleDays = n
+		}
+		webhookRetries := defaultWebhookRetries
+		if v := os.Getenv("SWDOCS_WEBHOOK_RETRIES"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				log.Fatal("Invalid SWDOCS_WEBHOOK_RETRIES: " + err.Error())
+			}
+			webhookRetries = n
+		}
+		webhookPrivate := false
+		if v := os.Getenv("SWDOCS_WEBHOOK_PRIVATE"); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				log.Fatal("Invalid SWDOCS_WEBHOOK_PRIVATE: " + err.Error())
+			}
+			webhookPrivate = b
+		}
+		tz := os.Getenv("SWDOCS_DEFAULT_TZ")
+		if tz == "" {
+			tz = defaultTimezone
+		}
+		timezone, err := time.LoadLocation(tz)
+		if err != nil {
+			log.Fatal("Invalid SWDOCS_DEFAULT_TZ: " + err.Error())
+		}
+		trashDays := defaultTrashDays
+		if v := os.Getenv("SWDOCS_TRASH_DAYS"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				log.Fatal("Invalid SWDOCS_TRASH_DAYS: " + err.Error())
+			}
+			trashDays = n
+		}
+
 		// Create, initialize and run the app.
 		c := swdocs.AppConfig{
-			Port:          port,
-			DbPath:        dbPath,
-			TemplatesPath: templatesPath,
+			Port:                 port,
+			DbPath:               dbPath,
+			TemplatesPath:        templatesPath,
+			LinkCheckInterval:    linkCheckInterval,
+			LinkCheckConcurrency: linkCheckConcurrency,
+			LinkCheckHostDelay:   linkCheckHostDelay,
+			LinkCheckTimeout:     linkCheckTimeout,
+			LinkCheckPrivate:     linkCheckPrivate,
+			StaleAfter:           time.Duration(staleDays) * 24 * time.Hour,
+			WebhookRetries:       webhookRetries,
+			WebhookBackoff:       envDuration("SWDOCS_WEBHOOK_BACKOFF", defaultWebhookBackoff),
+			WebhookTimeout:       envDuration("SWDOCS_WEBHOOK_TIMEOUT", defaultWebhookTimeout),
+			WebhookPrivate:       webhookPrivate,
+			TrashRetention:       time.Duration(trashDays) * 24 * time.Hour,
+			Timezone:             timezone,
 		}
 		a := swdocs.App{Config: c}
 		a.Initialize()
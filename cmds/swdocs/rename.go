@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/andrecp/swdocs"
+	log "github.com/sirupsen/logrus"
+)
+
+// runRename renames a SwDoc, its old name keeps redirecting to the new one.
+func runRename(args []string, baseURL string) {
+	cmd := flag.NewFlagSet("rename", flag.ExitOnError)
+	userFlag := cmd.String("user", "", "Override the user, useful for CI")
+	ifMatchFlag := cmd.String("if-match", "", "Only rename if the SwDoc is still at this revision (ETag)")
+
+	if err := parseArgs(cmd, args); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	oldName, newName := cmd.Arg(0), cmd.Arg(1)
+	if oldName == "" || newName == "" {
+		fmt.Println("The current and the new name of the SwDoc are required to rename it.")
+		os.Exit(1)
+	}
+
+	username, err := currentUser(*userFlag)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	header := http.Header{}
+	if *ifMatchFlag != "" {
+		header.Set("If-Match", quoteETag(*ifMatchFlag))
+	}
+
+	req := swdocs.RenameRequest{Name: newName, User: username}
+	resp, body, err := sendJSON("POST", baseURL+"/api/v1/swdocs/"+url.PathEscape(oldName)+"/rename", req, header)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		fmt.Println(string(body))
+		os.Exit(1)
+	}
+	fmt.Println(oldName + " renamed to " + newName + ", " + baseURL + "/" + oldName + " redirects to " + baseURL + "/" + newName)
+}
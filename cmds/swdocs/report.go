@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/andrecp/swdocs"
+	log "github.com/sirupsen/logrus"
+)
+
+const reportHelp = `Missing or unsupported report! You can use:
+  * swdocs report stale [--days 365] [--selector team=payments] # To list the SwDocs nobody updated lately, by owner
+`
+
+// runReport prints one of the reports of the server.
+func runReport(args []string, baseURL string) {
+	if len(args) < 1 || args[0] != "stale" {
+		fmt.Print(reportHelp)
+		os.Exit(1)
+	}
+
+	cmd := flag.NewFlagSet("report stale", flag.ExitOnError)
+	daysFlag := cmd.Int("days", 0, "SwDocs not updated for this many days are stale, the server's threshold is used if unset")
+	selectorFlag := cmd.String("selector", "", "Filter by labels, e.g. team=payments,tier!=3")
+	formatFlag := cmd.String("format", "human", "The format of the output, options are 'json' and 'human'")
+
+	if err := parseArgs(cmd, args[1:]); err != nil {
+		log.Fatal(err.Error())
+	}
+	if *formatFlag != "json" && *formatFlag != "human" {
+		fmt.Println("Unsupported format, options are 'json' and 'human'")
+		os.Exit(1)
+	}
+
+	q := url.Values{}
+	if *daysFlag > 0 {
+		q.Set("days", strconv.Itoa(*daysFlag))
+	}
+	if *selectorFlag != "" {
+		q.Set("selector", *selectorFlag)
+	}
+
+	resp, body, err := sendJSON("GET", baseURL+"/api/v1/reports/stale?"+q.Encode(), nil, nil)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		fmt.Println(string(body))
+		os.Exit(1)
+	}
+
+	groups := []swdocs.StaleGroup{}
+	if err := json.Unmarshal(body, &groups); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	if *formatFlag == "json" {
+		out, err := json.MarshalIndent(groups, "", "  ")
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	if len(groups) == 0 {
+		fmt.Println("No stale SwDocs.")
+		return
+	}
+	for _, group := range groups {
+		owner := group.Owner
+		if owner == "" {
+			owner = "Nobody"
+		}
+		fmt.Printf("%s (%d stale)\n", owner, len(group.SwDocs))
+		for _, doc := range group.SwDocs {
+			fmt.Println(" * " + doc.Name + ", last updated on " + doc.Updated.ToString() + " by " + doc.User + " -> " + baseURL + "/" + doc.Name)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+
+	"github.com/andrecp/swdocs"
+	log "github.com/sirupsen/logrus"
+)
+
+// runSync applies every SwDoc in a directory and, with --prune, deletes the
+// SwDocs in the server matching --selector which are not in the directory anymore.
+func runSync(args []string, baseURL string) {
+	cmd := flag.NewFlagSet("sync", flag.ExitOnError)
+	userFlag := cmd.String("user", "", "Override the user, useful for CI")
+	recursiveFlag := cmd.Bool("recursive", true, "Also sync the SwDocs in sub directories")
+	selectorFlag := cmd.String("selector", "", "Label selector of the SwDocs managed by this directory, e.g. managed-by=docs-repo")
+	pruneFlag := cmd.Bool("prune", false, "Delete the SwDocs matching --selector which are not in the directory")
+	yesFlag := cmd.Bool("yes", false, "Execute the plan instead of only printing it")
+
+	if err := parseArgs(cmd, args); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	path := cmd.Arg(0)
+	if path == "" {
+		fmt.Println("A path to a directory or file with SwDocs is required to sync.")
+		os.Exit(1)
+	}
+
+	if *pruneFlag && *selectorFlag == "" {
+		fmt.Println("--prune requires a --selector, otherwise every other SwDoc in the server would be deleted.")
+		os.Exit(1)
+	}
+
+	selector, err := swdocs.ParseSelector(*selectorFlag)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	username, err := currentUser(*userFlag)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	docs, err := readSwDocs(path, *recursiveFlag)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	local := make(map[string]bool)
+	for i := range docs {
+		docs[i].User = username
+		local[docs[i].Name] = true
+		if !selector.Matches(docs[i].Labels) {
+			fmt.Printf("Warning: %s doesn't match the selector %q, it won't be pruned once removed.\n", docs[i].Name, *selectorFlag)
+		}
+	}
+
+	existing, err := listSwDocs(baseURL, "")
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	remote := make(map[string]bool)
+	for _, doc := range existing {
+		remote[doc.Name] = true
+	}
+
+	var toPrune []string
+	if *pruneFlag {
+		managed, err := listSwDocs(baseURL, *selectorFlag)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		for _, doc := range managed {
+			if !local[doc.Name] {
+				toPrune = append(toPrune, doc.Name)
+			}
+		}
+		sort.Strings(toPrune)
+	}
+
+	fmt.Println("Plan:")
+	for _, doc := range docs {
+		if remote[doc.Name] {
+			fmt.Println("  ~ apply  " + doc.Name)
+		} else {
+			fmt.Println("  + create " + doc.Name)
+		}
+	}
+	for _, name := range toPrune {
+		fmt.Println("  - delete " + name)
+	}
+	if len(docs) == 0 && len(toPrune) == 0 {
+		fmt.Println("  Nothing to do.")
+		return
+	}
+
+	if !*yesFlag {
+		fmt.Println("\nNothing was changed, run again with --yes to execute the plan.")
+		return
+	}
+
+	if len(docs) > 0 {
+		resp, body, err := sendJSON("POST", baseURL+"/api/v1/swdocs/batch", docs, nil)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		if resp.StatusCode != http.StatusOK {
+			fmt.Println(string(body))
+			fmt.Println("Failed to apply the SwDocs, nothing was changed.")
+			os.Exit(1)
+		}
+		fmt.Printf("Applied %d SwDocs.\n", len(docs))
+	}
+
+	for _, name := range toPrune {
+		resp, body, err := sendJSON("DELETE", baseURL+"/api/v1/swdocs/"+url.PathEscape(name)+"?user="+url.QueryEscape(username), nil, nil)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		if resp.StatusCode != http.StatusOK {
+			fmt.Println(string(body))
+			fmt.Println("Failed to delete " + name)
+			os.Exit(1)
+		}
+		fmt.Println("Deleted " + name)
+	}
+}
+
+// listSwDocs lists every SwDoc in the server matching the label selector.
+func listSwDocs(baseURL, selector string) ([]swdocs.SwDoc, error) {
+	q := url.Values{}
+	q.Add("filter", "%")
+	if selector != "" {
+		q.Add("selector", selector)
+	}
+
+	resp, body, err := sendJSON("GET", baseURL+"/api/v1/swdocs/?"+q.Encode(), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list SwDocs: %s", body)
+	}
+
+	docs := []swdocs.SwDoc{}
+	err = json.Unmarshal(body, &docs)
+	return docs, err
+}
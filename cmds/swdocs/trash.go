@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/andrecp/swdocs"
+	log "github.com/sirupsen/logrus"
+)
+
+const trashHelp = `Missing or unsupported trash subcommand! You can use:
+  * swdocs trash list                # To list the deleted SwDocs which can be restored
+  * swdocs trash restore mysoftware  # To restore the deleted SwDoc mysoftware
+  * swdocs trash purge mysoftware    # To delete mysoftware for good
+  * swdocs trash purge --all [--days 30] # To delete for good every SwDoc deleted, or deleted more than 30 days ago
+`
+
+// runTrash manages the deleted SwDocs.
+func runTrash(args []string, baseURL string) {
+	if len(args) < 1 {
+		fmt.Print(trashHelp)
+		os.Exit(1)
+	}
+	subCmd := args[0]
+	cmd := flag.NewFlagSet("trash "+subCmd, flag.ExitOnError)
+
+	switch subCmd {
+	case "list":
+		formatFlag := cmd.String("format", "human", "The format of the output, options are 'json' and 'human'")
+		if err := parseArgs(cmd, args[1:]); err != nil {
+			log.Fatal(err.Error())
+		}
+		if *formatFlag != "json" && *formatFlag != "human" {
+			fmt.Println("Unsupported format, options are 'json' and 'human'")
+			os.Exit(1)
+		}
+
+		resp, body, err := sendJSON("GET", baseURL+"/api/v1/trash", nil, nil)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		if resp.StatusCode != http.StatusOK {
+			fmt.Println(string(body))
+			os.Exit(1)
+		}
+		docs := []swdocs.SwDoc{}
+		if err := json.Unmarshal(body, &docs); err != nil {
+			log.Fatal(err.Error())
+		}
+
+		if *formatFlag == "json" {
+			out, err := json.MarshalIndent(docs, "", "  ")
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+			fmt.Println(string(out))
+			return
+		}
+		if len(docs) == 0 {
+			fmt.Println("The trash is empty.")
+			return
+		}
+		for _, doc := range docs {
+			line := " * " + doc.Name + ", deleted on " + doc.Deleted.ToString()
+			if doc.DeletedBy != "" {
+				line += " by " + doc.DeletedBy
+			}
+			fmt.Println(line)
+		}
+
+	case "restore":
+		userFlag := cmd.String("user", "", "Override the user, useful for CI")
+		if err := parseArgs(cmd, args[1:]); err != nil {
+			log.Fatal(err.Error())
+		}
+		name := cmd.Arg(0)
+		if name == "" {
+			fmt.Println("The name of the SwDoc to restore is required, see swdocs trash list.")
+			os.Exit(1)
+		}
+		username, err := currentUser(*userFlag)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+
+		resp, body, err := sendJSON("POST", baseURL+"/api/v1/trash/"+url.PathEscape(name)+"/restore?user="+url.QueryEscape(username), nil, nil)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		if resp.StatusCode != http.StatusOK {
+			fmt.Println(string(body))
+			os.Exit(1)
+		}
+		fmt.Println("Restored " + name + " -> " + baseURL + "/" + name)
+
+	case "purge":
+		allFlag := cmd.Bool("all", false, "Purge every SwDoc of the trash instead of a single one")
+		daysFlag := cmd.Int("days", 0, "With --all, only purge the SwDocs deleted more than this many days ago")
+		if err := parseArgs(cmd, args[1:]); err != nil {
+			log.Fatal(err.Error())
+		}
+		name := cmd.Arg(0)
+		if (name == "") == !*allFlag {
+			fmt.Println("Either the name of a SwDoc or --all is required to purge the trash.")
+			os.Exit(1)
+		}
+
+		trashURL := baseURL + "/api/v1/trash/" + url.PathEscape(name)
+		if *allFlag {
+			trashURL = baseURL + "/api/v1/trash?days=" + strconv.Itoa(*daysFlag)
+		}
+		resp, body, err := sendJSON("DELETE", trashURL, nil, nil)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		if resp.StatusCode != http.StatusOK {
+			fmt.Println(string(body))
+			os.Exit(1)
+		}
+
+		if !*allFlag {
+			fmt.Println("Ok.")
+			return
+		}
+		var names []string
+		if err := json.Unmarshal(body, &names); err != nil {
+			log.Fatal(err.Error())
+		}
+		fmt.Printf("Purged %d SwDocs.\n", len(names))
+		for _, n := range names {
+			fmt.Println(" * " + n)
+		}
+
+	default:
+		fmt.Print(trashHelp)
+		os.Exit(1)
+	}
+}
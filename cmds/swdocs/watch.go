@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrecp/swdocs"
+	log "github.com/sirupsen/logrus"
+)
+
+// runWatch prints the changes to SwDocs as they happen. It reconnects when the
+// stream breaks, resuming after the last event it printed.
+func runWatch(args []string, baseURL string) {
+	cmd := flag.NewFlagSet("watch", flag.ExitOnError)
+	selectorFlag := cmd.String("selector", "", "Only the changes of SwDocs matching these labels, e.g. team=payments")
+	sinceFlag := cmd.Int64("since", -1, "Also print the events after this event id, 0 prints every past event")
+	formatFlag := cmd.String("format", "human", "The format of the output, options are 'json' and 'human'")
+
+	if err := parseArgs(cmd, args); err != nil {
+		log.Fatal(err.Error())
+	}
+	if *formatFlag != "json" && *formatFlag != "human" {
+		fmt.Println("Unsupported format, options are 'json' and 'human'")
+		os.Exit(1)
+	}
+
+	q := url.Values{}
+	if *selectorFlag != "" {
+		q.Set("selector", *selectorFlag)
+	}
+	streamURL := baseURL + "/api/v1/events?" + q.Encode()
+
+	lastEventID := ""
+	if *sinceFlag >= 0 {
+		lastEventID = strconv.FormatInt(*sinceFlag, 10)
+	}
+	retry := 3 * time.Second
+	for {
+		err := watchEvents(streamURL, &lastEventID, &retry, func(e swdocs.Event) {
+			if *formatFlag == "json" {
+				out, _ := json.Marshal(e)
+				fmt.Println(string(out))
+				return
+			}
+			printEvent(e)
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error()+", reconnecting in "+retry.String())
+		}
+		time.Sleep(retry)
+	}
+}
+
+// watchEvents reads the event stream until it breaks, keeping track of the
+// last event id and of the reconnection delay asked by the server.
+func watchEvents(streamURL string, lastEventID *string, retry *time.Duration, handle func(swdocs.Event)) error {
+	req, err := http.NewRequest("GET", streamURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if *lastEventID != "" {
+		req.Header.Set("Last-Event-ID", *lastEventID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("the server answered %s", resp.Status)
+	}
+
+	var id, data string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			// A blank line dispatches the event.
+			if data != "" {
+				var e swdocs.Event
+				if err := json.Unmarshal([]byte(data), &e); err != nil {
+					return err
+				}
+				handle(e)
+			}
+			if id != "" {
+				*lastEventID = id
+			}
+			id, data = "", ""
+			continue
+		}
+
+		field, value := line, ""
+		if i := strings.Index(line, ":"); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "id":
+			id = value
+		case "data":
+			data += value
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil {
+				*retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("the stream was closed")
+}
+
+func printEvent(e swdocs.Event) {
+	when := ""
+	if e.Created != nil {
+		when = e.Created.ToString() + " "
+	}
+	what := e.Type + " " + e.Name
+	switch e.Type {
+	case swdocs.EventRenamed:
+		what = "renamed " + e.OldName + " to " + e.Name
+	case swdocs.EventCreated, swdocs.EventUpdated:
+		what += fmt.Sprintf(" (revision %d)", e.Revision)
+	}
+	if e.User != "" {
+		what += " by " + e.User
+	}
+	fmt.Printf("%s#%d %s\n", when, e.ID, what)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andrecp/swdocs"
+)
+
+func TestWatchEventsKeepsTheLastEventID(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Last-Event-ID"); got != "" {
+			t.Errorf("got Last-Event-ID %q on the first connection, want none", got)
+		}
+		fmt.Fprint(w, "retry: 500\n\nid: 7\n\n")
+	}))
+	defer server.Close()
+
+	lastEventID := ""
+	retry := 3 * time.Second
+	handled := 0
+	watchEvents(server.URL, &lastEventID, &retry, func(swdocs.Event) { handled++ })
+
+	if lastEventID != "7" {
+		t.Errorf("got last event id %q, want the one the stream started at, 7", lastEventID)
+	}
+	if retry != 500*time.Millisecond {
+		t.Errorf("got retry %v, want 500ms", retry)
+	}
+	if handled != 0 {
+		t.Errorf("got %d events handled, want none", handled)
+	}
+}
+
+func TestWatchEventsResumes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Last-Event-ID"); got != "7" {
+			t.Errorf("got Last-Event-ID %q, want 7", got)
+		}
+		fmt.Fprint(w, "id: 8\nevent: created\ndata: {\"id\": 8, \"type\": \"created\", \"name\": \"rabbitmq\"}\n\nid: 8\n\n")
+	}))
+	defer server.Close()
+
+	lastEventID := "7"
+	retry := 3 * time.Second
+	var events []swdocs.Event
+	watchEvents(server.URL, &lastEventID, &retry, func(e swdocs.Event) { events = append(events, e) })
+
+	if len(events) != 1 || events[0].Name != "rabbitmq" {
+		t.Errorf("got events %+v, want the creation of rabbitmq", events)
+	}
+	if lastEventID != "8" {
+		t.Errorf("got last event id %q, want 8", lastEventID)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/andrecp/swdocs"
+	log "github.com/sirupsen/logrus"
+)
+
+const webhookHelp = `Missing or unsupported webhook subcommand! You can use:
+  * swdocs webhook add --url http://bot/swdocs [--events created,deleted] [--secret S] [--selector team=payments]
+  * swdocs webhook list
+  * swdocs webhook rm 1
+  * swdocs webhook deliveries 1   # To see the last delivery attempts of the webhook 1
+  * swdocs webhook ping 1         # To send a test event to the webhook 1
+  * swdocs webhook listen --port 9000 [--secret S] # To print the events received, for testing webhooks
+`
+
+// runWebhook manages the webhooks of the server.
+func runWebhook(args []string, baseURL string) {
+	if len(args) < 1 {
+		fmt.Print(webhookHelp)
+		os.Exit(1)
+	}
+	subCmd := args[0]
+	cmd := flag.NewFlagSet("webhook "+subCmd, flag.ExitOnError)
+
+	switch subCmd {
+	case "add":
+		urlFlag := cmd.String("url", "", "The URL the events are POSTed to")
+		eventsFlag := cmd.String("events", "", "Comma separated events to subscribe to, all of them if unset")
+		secretFlag := cmd.String("secret", "", "Secret to sign the payloads with, see the "+swdocs.SignatureHeader+" header")
+		selectorFlag := cmd.String("selector", "", "Only the events of SwDocs matching these labels, e.g. team=payments")
+		if err := parseArgs(cmd, args[1:]); err != nil {
+			log.Fatal(err.Error())
+		}
+		if *urlFlag == "" {
+			fmt.Println("--url is required to add a webhook.")
+			os.Exit(1)
+		}
+
+		hook := swdocs.Webhook{URL: *urlFlag, Secret: *secretFlag, Selector: *selectorFlag}
+		for _, e := range strings.Split(*eventsFlag, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				hook.Events = append(hook.Events, e)
+			}
+		}
+		resp, body, err := sendJSON("POST", baseURL+"/api/v1/webhooks", hook, nil)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		if resp.StatusCode != http.StatusCreated {
+			fmt.Println(string(body))
+			os.Exit(1)
+		}
+		if err := json.Unmarshal(body, &hook); err != nil {
+			log.Fatal(err.Error())
+		}
+		fmt.Printf("Webhook %d created.\n", hook.ID)
+
+	case "list":
+		if err := parseArgs(cmd, args[1:]); err != nil {
+			log.Fatal(err.Error())
+		}
+		resp, body, err := sendJSON("GET", baseURL+"/api/v1/webhooks", nil, nil)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		if resp.StatusCode != http.StatusOK {
+			fmt.Println(string(body))
+			os.Exit(1)
+		}
+		hooks := []swdocs.Webhook{}
+		if err := json.Unmarshal(body, &hooks); err != nil {
+			log.Fatal(err.Error())
+		}
+		for _, hook := range hooks {
+			events := "all events"
+			if len(hook.Events) > 0 {
+				events = strings.Join(hook.Events, ",")
+			}
+			line := fmt.Sprintf("%d %s (%s)", hook.ID, hook.URL, events)
+			if hook.Selector != "" {
+				line += " of " + hook.Selector
+			}
+			fmt.Println(line)
+		}
+
+	case "rm", "deliveries", "ping":
+		if err := parseArgs(cmd, args[1:]); err != nil {
+			log.Fatal(err.Error())
+		}
+		id := cmd.Arg(0)
+		if id == "" {
+			fmt.Println("The id of the webhook is required, see swdocs webhook list.")
+			os.Exit(1)
+		}
+
+		method, hookURL := "DELETE", baseURL+"/api/v1/webhooks/"+id
+		if subCmd == "deliveries" {
+			method, hookURL = "GET", hookURL+"/deliveries"
+		} else if subCmd == "ping" {
+			method, hookURL = "POST", hookURL+"/ping"
+		}
+		resp, body, err := sendJSON(method, hookURL, nil, nil)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		if resp.StatusCode != http.StatusOK {
+			fmt.Println(string(body))
+			os.Exit(1)
+		}
+
+		switch subCmd {
+		case "rm":
+			fmt.Println("Ok.")
+		case "deliveries":
+			deliveries := []swdocs.WebhookDelivery{}
+			if err := json.Unmarshal(body, &deliveries); err != nil {
+				log.Fatal(err.Error())
+			}
+			for _, d := range deliveries {
+				printDelivery(d)
+			}
+		case "ping":
+			d := swdocs.WebhookDelivery{}
+			if err := json.Unmarshal(body, &d); err != nil {
+				log.Fatal(err.Error())
+			}
+			printDelivery(d)
+			if !d.Succeeded() {
+				os.Exit(1)
+			}
+		}
+
+	case "listen":
+		portFlag := cmd.String("port", "9000", "Port to listen at")
+		secretFlag := cmd.String("secret", "", "Reject the events not signed with this secret")
+		if err := parseArgs(cmd, args[1:]); err != nil {
+			log.Fatal(err.Error())
+		}
+
+		fmt.Println("Listening for events on :" + *portFlag)
+		log.Fatal(http.ListenAndServe(":"+*portFlag, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			signature := r.Header.Get(swdocs.SignatureHeader)
+			if *secretFlag != "" && !hmac.Equal([]byte(signature), []byte(swdocs.SignPayload(*secretFlag, body))) {
+				fmt.Println("Rejected an event with a wrong signature")
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Printf("%s %s: %s\n", r.Header.Get(swdocs.EventHeader), r.Header.Get(swdocs.DeliveryHeader), string(body))
+		})))
+
+	default:
+		fmt.Print(webhookHelp)
+		os.Exit(1)
+	}
+}
+
+func printDelivery(d swdocs.WebhookDelivery) {
+	outcome := "ok"
+	if d.Error != "" {
+		outcome = d.Error
+	} else if !d.Succeeded() {
+		outcome = "failed"
+	}
+	when := ""
+	if d.Delivered != nil {
+		when = d.Delivered.ToString() + " "
+	}
+	fmt.Printf("%sevent %d (%s) attempt %d: %d %s in %dms\n", when, d.EventID, d.EventType, d.Attempt, d.StatusCode, outcome, d.DurationMs)
+}
@@ -0,0 +1,134 @@
+package swdocs
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// FieldChange is a difference in a single field between the stored SwDoc and
+// the one being applied. Old is unset for added fields and New for removed ones.
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old,omitempty"`
+	New   interface{} `json:"new,omitempty"`
+}
+
+// planSwDoc computes what applying swdoc would do without writing anything.
+func planSwDoc(db sqlExecutor, swdoc *SwDoc) (ApplyResult, error) {
+	result := ApplyResult{Name: swdoc.Name}
+
+	if err := checkSwDocNames(db, swdoc); err != nil {
+		return result, err
+	}
+
+	stored, err := getSwDocByName(db, swdoc.Name)
+	if err != nil {
+		return result, err
+	}
+
+	if stored.Name == "" {
+		result.Action = ActionCreated
+		result.Changes, err = diffSwDocs(nil, swdoc)
+	} else {
+		result.Action = ActionUpdated
+		result.Changes, err = diffSwDocs(&stored, swdoc)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	if stored.Name != "" && len(result.Changes) == 0 {
+		result.Action = ActionUnchanged
+	}
+	return result, nil
+}
+
+// diffSwDocs lists the changed fields between two SwDocs, ignoring the ones
+// managed by the server. A nil old SwDoc means every field is added.
+func diffSwDocs(old, new *SwDoc) ([]FieldChange, error) {
+	var oldView interface{} = map[string]interface{}{}
+	if old != nil {
+		var err error
+		if oldView, err = comparableView(old); err != nil {
+			return nil, err
+		}
+	}
+	newView, err := comparableView(new)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []FieldChange
+	diffValues("", oldView, newView, &changes)
+	return changes, nil
+}
+
+// comparableView is the generic JSON representation of the user editable fields of a SwDoc.
+func comparableView(s *SwDoc) (interface{}, error) {
+	c := *s
+	c.ID = 0
+	c.Revision = 0
+	c.Created = nil
+	c.Updated = nil
+	c.Stale = false
+	c.Deleted = nil
+	c.DeletedBy = ""
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	err = json.Unmarshal(b, &v)
+	return v, err
+}
+
+func diffValues(path string, old, new interface{}, changes *[]FieldChange) {
+	oldMap, oldIsMap := old.(map[string]interface{})
+	newMap, newIsMap := new.(map[string]interface{})
+	if oldIsMap && newIsMap {
+		keys := make(map[string]bool)
+		for k := range oldMap {
+			keys[k] = true
+		}
+		for k := range newMap {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+
+		for _, k := range sorted {
+			field := k
+			if path != "" {
+				field = path + "." + k
+			}
+			diffValues(field, oldMap[k], newMap[k], changes)
+		}
+		return
+	}
+
+	oldSlice, oldIsSlice := old.([]interface{})
+	newSlice, newIsSlice := new.([]interface{})
+	if oldIsSlice && newIsSlice {
+		for i := 0; i < len(oldSlice) || i < len(newSlice); i++ {
+			var o, n interface{}
+			if i < len(oldSlice) {
+				o = oldSlice[i]
+			}
+			if i < len(newSlice) {
+				n = newSlice[i]
+			}
+			diffValues(fmt.Sprintf("%s[%d]", path, i), o, n, changes)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(old, new) {
+		*changes = append(*changes, FieldChange{Field: path, Old: old, New: new})
+	}
+}
@@ -0,0 +1,121 @@
+package swdocs
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// subscriberBuffer is how many events a subscriber can fall behind before it
+// is dropped, it then reconnects and catches up from the database.
+const subscriberBuffer = 64
+
+// matches tells whether the SwDoc of the event matches the selector.
+func (e Event) matches(sel Selector) bool {
+	if e.SwDoc == nil {
+		return len(sel) == 0
+	}
+	return sel.Matches(e.SwDoc.Labels)
+}
+
+// writeEvent writes the event in the Server-Sent Events format.
+func writeEvent(w io.Writer, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
+
+// eventBroker fans the events recorded in the database out to the
+// subscribers of the event stream as soon as they are committed.
+type eventBroker struct {
+	db   *sql.DB
+	wake chan struct{}
+
+	mutex       sync.Mutex
+	subscribers map[chan Event]bool
+}
+
+func newEventBroker(db *sql.DB) *eventBroker {
+	return &eventBroker{
+		db:          db,
+		wake:        make(chan struct{}, 1),
+		subscribers: make(map[chan Event]bool),
+	}
+}
+
+// Notify tells the broker there are new events, it never blocks.
+func (b *eventBroker) Notify() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run broadcasts the events recorded after it started, forever.
+func (b *eventBroker) Run() {
+	lastID, err := getLastEventID(b.db)
+	if err != nil {
+		log.Error("Event broker failed to start: " + err.Error())
+		return
+	}
+
+	for range b.wake {
+		for {
+			events, err := getEventsAfter(b.db, lastID, 100)
+			if err != nil {
+				log.Error("Event broker failed to read events: " + err.Error())
+				break
+			}
+			if len(events) == 0 {
+				break
+			}
+			for _, e := range events {
+				lastID = e.ID
+				b.broadcast(e)
+			}
+		}
+	}
+}
+
+func (b *eventBroker) broadcast(e Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			// Too slow, closing the channel ends its stream.
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe returns a channel receiving every new event, it is closed if the
+// subscriber falls behind.
+func (b *eventBroker) subscribe() chan Event {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	ch := make(chan Event, subscriberBuffer)
+	b.subscribers[ch] = true
+	return ch
+}
+
+func (b *eventBroker) unsubscribe(ch chan Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.subscribers[ch] {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
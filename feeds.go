@@ -0,0 +1,189 @@
+package swdocs
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// feedLength is how many events are in a feed.
+const feedLength = 50
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Author  *atomAuthor `xml:"author,omitempty"`
+	Summary string      `xml:"summary,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description,omitempty"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+// requestBaseURL is the URL the client reached the app at, which may be behind a proxy.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
+
+// eventTitle describes the event in a sentence.
+func eventTitle(e Event) string {
+	title := e.Name + " was " + e.Type
+	if e.Type == EventRenamed {
+		title = e.OldName + " was renamed to " + e.Name
+	}
+	if e.User != "" {
+		title += " by " + e.User
+	}
+	return title
+}
+
+// storedSwDocEvent describes the last update of a SwDoc without recorded events.
+func storedSwDocEvent(doc SwDoc) Event {
+	return Event{
+		Type:     EventUpdated,
+		Name:     doc.Name,
+		Revision: doc.Revision,
+		User:     doc.User,
+		Created:  doc.Updated,
+		SwDoc:    &doc,
+	}
+}
+
+// eventGUID identifies the entry of an event, the events not recorded are
+// identified by the revision of their SwDoc instead.
+func eventGUID(baseURL string, e Event) string {
+	if e.ID == 0 {
+		return fmt.Sprintf("%s/%s#revision-%d", baseURL, url.PathEscape(e.Name), e.Revision)
+	}
+	return fmt.Sprintf("%s/api/v1/events#%d", baseURL, e.ID)
+}
+
+func eventTime(e Event) time.Time {
+	if e.Created == nil {
+		return time.Time{}
+	}
+	return time.Time(*e.Created)
+}
+
+func eventSummary(e Event) string {
+	if e.SwDoc == nil {
+		return ""
+	}
+	return e.SwDoc.Description
+}
+
+// buildAtomFeed builds an Atom feed of the events, newest first. selfURL is
+// the URL of the feed itself.
+func buildAtomFeed(baseURL, selfURL, title string, events []Event) atomFeed {
+	feed := atomFeed{
+		Title: title,
+		ID:    selfURL,
+		Links: []atomLink{
+			{Href: selfURL, Rel: "self", Type: "application/atom+xml"},
+			{Href: baseURL + "/", Rel: "alternate", Type: "text/html"},
+		},
+		Entries: []atomEntry{},
+	}
+
+	updated := time.Time{}
+	for _, e := range events {
+		t := eventTime(e)
+		if t.After(updated) {
+			updated = t
+		}
+		entry := atomEntry{
+			Title:   eventTitle(e),
+			ID:      eventGUID(baseURL, e),
+			Updated: t.UTC().Format(time.RFC3339),
+			Link:    atomLink{Href: baseURL + "/" + url.PathEscape(e.Name), Rel: "alternate"},
+			Summary: eventSummary(e),
+		}
+		// Atom requires an author, the changes of nobody are the ones of swdocs.
+		entry.Author = &atomAuthor{Name: "swdocs"}
+		if e.User != "" {
+			entry.Author.Name = e.User
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	// A feed without entries was last updated now, when it was built.
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+	return feed
+}
+
+// buildRSSFeed builds an RSS 2.0 feed of the events, newest first.
+func buildRSSFeed(baseURL, title string, events []Event) rssFeed {
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       title,
+			Link:        baseURL + "/",
+			Description: title,
+		},
+	}
+
+	for _, e := range events {
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       eventTitle(e),
+			Link:        baseURL + "/" + url.PathEscape(e.Name),
+			Description: eventSummary(e),
+			GUID:        rssGUID{Value: eventGUID(baseURL, e)},
+			PubDate:     eventTime(e).UTC().Format(time.RFC1123Z),
+		})
+	}
+	return feed
+}
@@ -0,0 +1,85 @@
+package swdocs
+
+import (
+	"encoding/xml"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// insertSwDocWithoutEvents stores a SwDoc the way versions recording no events did.
+func insertSwDocWithoutEvents(t *testing.T, a *App, name, labels string) {
+	t.Helper()
+	_, err := a.DB.Exec(`INSERT INTO swdocs (name, description, user, sections, labels, created, updated)
+		VALUES (?, 'Stored before events', '', '[]', ?, '2020-01-02T03:04:05Z', '2020-01-02T03:04:05Z')`, name, labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getTestAtomFeed(t *testing.T, a *App, path string) atomFeed {
+	t.Helper()
+	rec := serveTestRequest(a, "GET", path, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s: got status %d, want %d: %s", path, rec.Code, http.StatusOK, rec.Body)
+	}
+	var feed atomFeed
+	if err := xml.Unmarshal(rec.Body.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+	if updated, err := time.Parse(time.RFC3339, feed.Updated); err != nil || updated.IsZero() {
+		t.Errorf("%s: got updated %q, want a time", path, feed.Updated)
+	}
+	for _, entry := range feed.Entries {
+		if entry.Author == nil || entry.Author.Name == "" {
+			t.Errorf("%s: got entry %q without an author", path, entry.Title)
+		}
+	}
+	return feed
+}
+
+func TestFeedOfSwDocsWithoutEvents(t *testing.T) {
+	a := newTestApp(t)
+	insertSwDocWithoutEvents(t, a, "legacy", `{"team": "payments"}`)
+	insertSwDocWithoutEvents(t, a, "other", `{"team": "search"}`)
+	applyTestSwDoc(t, a, SwDoc{Name: "rabbitmq", Description: "A broker", Labels: labelMap{"team": "payments"}})
+
+	feed := getTestAtomFeed(t, a, "/feed.atom")
+	if len(feed.Entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(feed.Entries))
+	}
+	if feed.Entries[0].Title != "rabbitmq was created by test" {
+		t.Errorf("got first entry %q, want the recorded event", feed.Entries[0].Title)
+	}
+	if feed.Entries[1].Updated != "2020-01-02T03:04:05Z" || feed.Entries[1].Author.Name != "swdocs" {
+		t.Errorf("got entry %+v, want the stored update of a SwDoc without events", feed.Entries[1])
+	}
+
+	feed = getTestAtomFeed(t, a, "/feed.atom?selector=team%3Dpayments")
+	if len(feed.Entries) != 2 {
+		t.Fatalf("got %d entries, want rabbitmq and legacy", len(feed.Entries))
+	}
+	for _, entry := range feed.Entries {
+		if entry.Link.Href == "http://example.com/other" {
+			t.Error("got the SwDoc of another team")
+		}
+	}
+
+	feed = getTestAtomFeed(t, a, "/legacy/feed.atom")
+	if len(feed.Entries) != 1 {
+		t.Errorf("got %d entries in the feed of legacy, want 1", len(feed.Entries))
+	}
+}
+
+func TestEmptyFeed(t *testing.T) {
+	a := newTestApp(t)
+	feed := getTestAtomFeed(t, a, "/feed.atom")
+	if len(feed.Entries) != 0 {
+		t.Errorf("got %d entries, want none", len(feed.Entries))
+	}
+
+	rec := serveTestRequest(a, "GET", "/feed.rss", "")
+	if rec.Code != http.StatusOK {
+		t.Errorf("got status %d for the RSS feed, want %d", rec.Code, http.StatusOK)
+	}
+}
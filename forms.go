@@ -0,0 +1,291 @@
+package swdocs
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cookies used by the web forms.
+const (
+	csrfCookie  = "swdocs_csrf"
+	userCookie  = "swdocs_user"
+	flashCookie = "swdocs_flash"
+)
+
+// The most sections of a SwDoc and links of a section the forms accept, the
+// counts are sent by the browser so they can't be trusted.
+const (
+	maxFormSections = 100
+	maxFormLinks    = 200
+)
+
+var indexedFieldRegexp = regexp.MustCompile(`^(aliases|labels|related)\b`)
+
+// swDocForm is what the create and edit pages render.
+type swDocForm struct {
+	SwDoc
+	New         bool
+	AliasesText string
+	LabelsText  string
+	RelatedText string
+	CSRF        string
+	// Errors has the validation errors by field, e.g. "sections[0].header",
+	// the ones not about a single field are under "".
+	Errors map[string]string
+}
+
+// newSwDocForm fills the form fields of a SwDoc.
+func newSwDocForm(s SwDoc, isNew bool, csrf string) swDocForm {
+	var labels []string
+	for k, v := range s.Labels {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+	var related []string
+	for _, rel := range s.Related {
+		related = append(related, rel.Type+" "+rel.Name)
+	}
+	return swDocForm{
+		SwDoc:       s,
+		New:         isNew,
+		AliasesText: strings.Join(s.Aliases, ", "),
+		LabelsText:  strings.Join(labels, "\n"),
+		RelatedText: strings.Join(related, "\n"),
+		CSRF:        csrf,
+		Errors:      make(map[string]string),
+	}
+}
+
+// addErrors shows the validation errors next to their fields, the errors of
+// aliases, labels and related are shown next to their text box.
+func (f *swDocForm) addErrors(errs ValidationErrors) {
+	for _, e := range errs {
+		field := e.Field
+		if m := indexedFieldRegexp.FindString(field); m != "" {
+			field = m
+		}
+		message := e.Message
+		if field != e.Field {
+			message = e.Field + " " + message
+		}
+		if f.Errors[field] != "" {
+			message = f.Errors[field] + "; " + message
+		}
+		f.Errors[field] = message
+	}
+}
+
+// formText reads a multi-line field, browsers send its line breaks as \r\n.
+func formText(form url.Values, field string) string {
+	return strings.TrimSpace(strings.ReplaceAll(form.Get(field), "\r\n", "\n"))
+}
+
+// parseSwDocForm reads the SwDoc submitted by the create or edit form, along
+// with the errors of the fields which can't be parsed.
+func parseSwDocForm(r *http.Request) (SwDoc, ValidationErrors) {
+	var errs ValidationErrors
+	form := r.PostForm
+	s := SwDoc{
+		Name:        strings.TrimSpace(form.Get("name")),
+		Description: formText(form, "description"),
+		User:        strings.TrimSpace(form.Get("user")),
+	}
+	s.Revision, _ = strconv.ParseInt(form.Get("revision"), 10, 64)
+
+	for _, alias := range strings.FieldsFunc(form.Get("aliases"), func(r rune) bool { return r == ',' || r == ' ' || r == '\n' || r == '\r' }) {
+		s.Aliases = append(s.Aliases, alias)
+	}
+
+	for _, line := range strings.Split(form.Get("labels"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			errs.add("labels", "%q must be key=value", line)
+			continue
+		}
+		if s.Labels == nil {
+			s.Labels = make(labelMap)
+		}
+		s.Labels[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	for _, line := range strings.Split(form.Get("related"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			errs.add("related", "%q must be a type and a name, e.g. depends-on rabbitmq", strings.TrimSpace(line))
+			continue
+		}
+		s.Related = append(s.Related, Relation{Type: fields[0], Name: fields[1]})
+	}
+
+	sections, ok := formCount(form, "sections", maxFormSections)
+	if !ok {
+		errs.add("", "a SwDoc can have up to %d sections", maxFormSections)
+		return s, errs
+	}
+	for i := 0; i < sections; i++ {
+		prefix := fmt.Sprintf("section-%d-", i)
+		sec := section{
+			Header:      strings.TrimSpace(form.Get(prefix + "header")),
+			Description: formText(form, prefix+"description"),
+			Links:       linkSlice{},
+		}
+		links, ok := formCount(form, prefix+"links", maxFormLinks)
+		if !ok {
+			errs.add("", "a section can have up to %d links", maxFormLinks)
+			return s, errs
+		}
+		for j := 0; j < links; j++ {
+			prefix := fmt.Sprintf("link-%d-%d-", i, j)
+			sec.Links = append(sec.Links, link{
+				URL:         strings.TrimSpace(form.Get(prefix + "url")),
+				Description: strings.TrimSpace(form.Get(prefix + "description")),
+				Slug:        strings.TrimSpace(form.Get(prefix + "slug")),
+			})
+		}
+		s.Sections = append(s.Sections, sec)
+	}
+
+	return s, errs
+}
+
+// formCount reads how many rows of a kind the form has, it tells whether the
+// count is missing or between 0 and max.
+func formCount(form url.Values, field string, max int) (int, bool) {
+	v := form.Get(field)
+	if v == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 || n > max {
+		return 0, false
+	}
+	return n, true
+}
+
+// applyFormAction adds or removes the rows of sections and links as asked by
+// the submit button used, e.g. "add-link-0" or "remove-section-2".
+func applyFormAction(s *SwDoc, action string) {
+	var i, j int
+	switch {
+	case action == "add-section" && len(s.Sections) < maxFormSections:
+		s.Sections = append(s.Sections, section{Links: linkSlice{{}}})
+	case scanAction(action, "remove-section-%d", &i) && i < len(s.Sections):
+		s.Sections = append(s.Sections[:i], s.Sections[i+1:]...)
+	case scanAction(action, "add-link-%d", &i) && i < len(s.Sections) && len(s.Sections[i].Links) < maxFormLinks:
+		s.Sections[i].Links = append(s.Sections[i].Links, link{})
+	case scanAction(action, "remove-link-%d-%d", &i, &j) && i < len(s.Sections) && j < len(s.Sections[i].Links):
+		s.Sections[i].Links = append(s.Sections[i].Links[:j], s.Sections[i].Links[j+1:]...)
+	}
+}
+
+func scanAction(action, format string, a ...interface{}) bool {
+	n, err := fmt.Sscanf(action, format, a...)
+	return err == nil && n == len(a)
+}
+
+// dropEmptyRows removes the link rows left blank, and the sections without
+// a header nor links, so unused rows of the form don't fail the validation.
+func (s *SwDoc) dropEmptyRows() {
+	var sections sectionSlice
+	for _, sec := range s.Sections {
+		links := linkSlice{}
+		for _, l := range sec.Links {
+			if l.URL != "" || l.Description != "" || l.Slug != "" {
+				links = append(links, l)
+			}
+		}
+		sec.Links = links
+		if sec.Header != "" || sec.Description != "" || len(links) > 0 {
+			sections = append(sections, sec)
+		}
+	}
+	s.Sections = sections
+}
+
+// csrfToken returns the token of the double submit cookie CSRF protection,
+// creating the cookie on the first visit.
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(csrfCookie); err == nil && c.Value != "" {
+		return c.Value
+	}
+
+	b := make([]byte, 32)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token
+}
+
+// validCSRF tells whether the token submitted matches the one of the cookie,
+// other sites can make browsers submit forms but can't read the cookie.
+func validCSRF(r *http.Request) bool {
+	c, err := r.Cookie(csrfCookie)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.Value), []byte(r.PostForm.Get("csrf"))) == 1
+}
+
+// rememberUser keeps the user who submitted a form to fill the next ones in.
+func rememberUser(w http.ResponseWriter, user string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     userCookie,
+		Value:    user,
+		Path:     "/",
+		Expires:  time.Now().AddDate(1, 0, 0),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// setFlash keeps a message to show in the next page rendered, after a redirect.
+func setFlash(w http.ResponseWriter, message string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookie,
+		Value:    url.QueryEscape(message),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// popFlash returns the message kept by setFlash, if any, and forgets it.
+func popFlash(w http.ResponseWriter, r *http.Request) string {
+	c, err := r.Cookie(flashCookie)
+	if err != nil {
+		return ""
+	}
+	http.SetCookie(w, &http.Cookie{Name: flashCookie, Path: "/", MaxAge: -1})
+	message, _ := url.QueryUnescape(c.Value)
+	return message
+}
+
+// formUser is the user remembered from the last form submitted, if any.
+func formUser(r *http.Request) string {
+	if c, err := r.Cookie(userCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
@@ -0,0 +1,57 @@
+package swdocs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const multiLineDescription = "Runbooks:\n\n- restart\n- failover\n\n```\nrabbitmqctl status\n```"
+
+func TestEditPageKeepsMultiLineSectionDescriptions(t *testing.T) {
+	a := newTestApp(t)
+	applyTestSwDoc(t, a, SwDoc{Name: "rabbitmq", Description: "A broker", Sections: sectionSlice{{
+		Header:      "Operations",
+		Description: multiLineDescription,
+		Links:       linkSlice{},
+	}}})
+
+	rec := serveTestRequest(a, "GET", "/rabbitmq/edit", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	want := `<textarea id="section-0-description" name="section-0-description" rows="3">` + multiLineDescription + `</textarea>`
+	if !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("the edit page doesn't have the section description in a textarea, want %q", want)
+	}
+}
+
+func TestParseSwDocFormNormalizesLineBreaks(t *testing.T) {
+	form := url.Values{
+		"name":                  {"rabbitmq"},
+		"description":           {"A broker\r\nfor queues"},
+		"user":                  {"test"},
+		"sections":              {"1"},
+		"section-0-header":      {"Operations"},
+		"section-0-description": {strings.ReplaceAll(multiLineDescription, "\n", "\r\n")},
+		"section-0-links":       {"0"},
+	}
+	r := httptest.NewRequest("POST", "/new", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := r.ParseForm(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, errs := parseSwDocForm(r)
+	if len(errs) > 0 {
+		t.Fatalf("got errors %v", errs)
+	}
+	if s.Description != "A broker\nfor queues" {
+		t.Errorf("got description %q", s.Description)
+	}
+	if len(s.Sections) != 1 || s.Sections[0].Description != multiLineDescription {
+		t.Errorf("got sections %+v, want the description with its line breaks", s.Sections)
+	}
+}
@@ -3,7 +3,10 @@ module github.com/andrecp/swdocs
 go 1.15
 
 require (
+	github.com/evanphx/json-patch/v5 v5.9.11
 	github.com/gorilla/mux v1.8.0
 	github.com/mattn/go-sqlite3 v1.14.5
 	github.com/sirupsen/logrus v1.7.0
-)
\ No newline at end of file
+	github.com/yuin/goldmark v1.7.8
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,391 @@
+package swdocs
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Graph is the graph of the relations between SwDocs. Relations are
+// normalized so used-by shows as a depends-on edge the other way around, and
+// see-also, which has no direction, only once.
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// GraphNode is a SwDoc of the graph.
+type GraphNode struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// GraphEdge is a relation between two SwDocs, From depends on To or is to be seen along with it.
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Type string `json:"type"`
+}
+
+// Sizes of the SVG rendering of a graph, in pixels.
+const (
+	graphMargin      = 20
+	graphNodeHeight  = 30
+	graphNodePadding = 20
+	graphNodeGap     = 20
+	graphLayerGap    = 60
+	// graphCharWidth is roughly the width of a character of the node names.
+	graphCharWidth = 8
+	// graphSweeps is how many times nodes are reordered to reduce crossings.
+	graphSweeps = 4
+)
+
+// graphLayout is where the nodes and edges of a graph are drawn.
+type graphLayout struct {
+	Width, Height int
+	Nodes         []layoutNode
+	Edges         []layoutEdge
+}
+
+type layoutNode struct {
+	GraphNode
+	X, Y, Width, Height int
+	// Focus is set on the SwDoc the graph is about.
+	Focus bool
+	layer int
+}
+
+type layoutEdge struct {
+	X1, Y1, X2, Y2 int
+	Type           string
+}
+
+// newGraph builds the graph of the relations between the given SwDocs,
+// relations to SwDocs not in nodes are left out.
+func newGraph(nodes []GraphNode, relations map[string][]Relation) Graph {
+	g := Graph{Nodes: nodes, Edges: []GraphEdge{}}
+	known := make(map[string]bool)
+	for _, n := range nodes {
+		known[n.Name] = true
+	}
+
+	seen := make(map[GraphEdge]bool)
+	for _, n := range nodes {
+		for _, rel := range relations[n.Name] {
+			if !known[rel.Name] {
+				continue
+			}
+			e := GraphEdge{From: n.Name, To: rel.Name, Type: rel.Type}
+			switch rel.Type {
+			case RelationUsedBy:
+				e = GraphEdge{From: rel.Name, To: n.Name, Type: RelationDependsOn}
+			case RelationSeeAlso:
+				if e.From > e.To {
+					e.From, e.To = e.To, e.From
+				}
+			}
+			if !seen[e] {
+				seen[e] = true
+				g.Edges = append(g.Edges, e)
+			}
+		}
+	}
+
+	sort.Slice(g.Edges, func(i, j int) bool {
+		a, b := g.Edges[i], g.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.Type < b.Type
+	})
+	return g
+}
+
+// connected leaves out the SwDocs without relations.
+func (g Graph) connected() Graph {
+	linked := make(map[string]bool)
+	for _, e := range g.Edges {
+		linked[e.From] = true
+		linked[e.To] = true
+	}
+	return g.subgraph(linked)
+}
+
+// neighborhood is the part of the graph up to depth relations away from the SwDoc called name.
+func (g Graph) neighborhood(name string, depth int) Graph {
+	neighbors := make(map[string][]string)
+	for _, e := range g.Edges {
+		neighbors[e.From] = append(neighbors[e.From], e.To)
+		neighbors[e.To] = append(neighbors[e.To], e.From)
+	}
+
+	keep := map[string]bool{name: true}
+	current := []string{name}
+	for i := 0; i < depth && len(current) > 0; i++ {
+		var next []string
+		for _, n := range current {
+			for _, m := range neighbors[n] {
+				if !keep[m] {
+					keep[m] = true
+					next = append(next, m)
+				}
+			}
+		}
+		current = next
+	}
+	return g.subgraph(keep)
+}
+
+func (g Graph) subgraph(keep map[string]bool) Graph {
+	sub := Graph{Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	for _, n := range g.Nodes {
+		if keep[n.Name] {
+			sub.Nodes = append(sub.Nodes, n)
+		}
+	}
+	for _, e := range g.Edges {
+		if keep[e.From] && keep[e.To] {
+			sub.Edges = append(sub.Edges, e)
+		}
+	}
+	return sub
+}
+
+// DOT renders the graph in the Graphviz DOT language.
+func (g Graph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph swdocs {\n")
+	b.WriteString("  node [shape=box];\n")
+	for _, n := range g.Nodes {
+		b.WriteString("  " + dotQuote(n.Name))
+		if n.Description != "" {
+			b.WriteString(" [tooltip=" + dotQuote(MarkdownToText(n.Description)) + "]")
+		}
+		b.WriteString(";\n")
+	}
+	for _, e := range g.Edges {
+		b.WriteString("  " + dotQuote(e.From) + " -> " + dotQuote(e.To) + " [label=" + dotQuote(e.Type))
+		if e.Type == RelationSeeAlso {
+			b.WriteString(", style=dashed, dir=none")
+		}
+		b.WriteString("];\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
+
+// layout places the nodes of the graph in layers, SwDocs above the ones they
+// depend on, ordering each layer to keep the edges from crossing.
+func (g Graph) layout(focus string) graphLayout {
+	index := make(map[string]int)
+	nodes := make([]layoutNode, len(g.Nodes))
+	for i, n := range g.Nodes {
+		index[n.Name] = i
+		nodes[i] = layoutNode{
+			GraphNode: n,
+			Width:     utf8.RuneCountInString(n.Name)*graphCharWidth + graphNodePadding,
+			Height:    graphNodeHeight,
+			Focus:     n.Name == focus,
+		}
+	}
+
+	// Each SwDoc goes one layer below the lowest SwDoc depending on it, the
+	// edges closing a cycle are ignored.
+	dependsOn := make([][]int, len(nodes))
+	for _, e := range g.Edges {
+		if e.Type == RelationDependsOn {
+			dependsOn[index[e.From]] = append(dependsOn[index[e.From]], index[e.To])
+		}
+	}
+	for _, i := range topologicalOrder(dependsOn) {
+		for _, j := range dependsOn[i] {
+			if nodes[j].layer <= nodes[i].layer && !reaches(dependsOn, j, i) {
+				nodes[j].layer = nodes[i].layer + 1
+			}
+		}
+	}
+
+	var layers [][]int
+	for i := range nodes {
+		for len(layers) <= nodes[i].layer {
+			layers = append(layers, nil)
+		}
+		layers[nodes[i].layer] = append(layers[nodes[i].layer], i)
+	}
+	orderLayers(layers, g.Edges, index)
+
+	l := graphLayout{Nodes: nodes}
+	widths := make([]int, len(layers))
+	for i, layer := range layers {
+		for j, n := range layer {
+			if j > 0 {
+				widths[i] += graphNodeGap
+			}
+			widths[i] += nodes[n].Width
+		}
+		if widths[i] > l.Width {
+			l.Width = widths[i]
+		}
+	}
+	for i, layer := range layers {
+		x := graphMargin + (l.Width-widths[i])/2
+		for _, n := range layer {
+			nodes[n].X = x
+			nodes[n].Y = graphMargin + i*(graphNodeHeight+graphLayerGap)
+			x += nodes[n].Width + graphNodeGap
+		}
+	}
+	l.Width += 2 * graphMargin
+	l.Height = 2*graphMargin + len(layers)*graphNodeHeight + (len(layers)-1)*graphLayerGap
+	if len(layers) == 0 {
+		l.Height = 2 * graphMargin
+	}
+
+	for _, e := range g.Edges {
+		l.Edges = append(l.Edges, edgeBetween(nodes[index[e.From]], nodes[index[e.To]], e.Type))
+	}
+	return l
+}
+
+// topologicalOrder orders the nodes so each one goes before the ones it
+// points to, but for the edges closing a cycle.
+func topologicalOrder(edges [][]int) []int {
+	visited := make([]bool, len(edges))
+	var order []int
+	var visit func(i int)
+	visit = func(i int) {
+		visited[i] = true
+		for _, j := range edges[i] {
+			if !visited[j] {
+				visit(j)
+			}
+		}
+		order = append(order, i)
+	}
+	for i := range edges {
+		if !visited[i] {
+			visit(i)
+		}
+	}
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	return order
+}
+
+// reaches tells whether there is a path from one node to another.
+func reaches(edges [][]int, from, to int) bool {
+	visited := make([]bool, len(edges))
+	stack := []int{from}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if i == to {
+			return true
+		}
+		if visited[i] {
+			continue
+		}
+		visited[i] = true
+		stack = append(stack, edges[i]...)
+	}
+	return false
+}
+
+// orderLayers sorts each layer by the average position of the neighbors of
+// its nodes in the layers around it, going down and up a few times.
+func orderLayers(layers [][]int, edges []GraphEdge, index map[string]int) {
+	neighbors := make(map[int][]int)
+	for _, e := range edges {
+		from, to := index[e.From], index[e.To]
+		neighbors[from] = append(neighbors[from], to)
+		neighbors[to] = append(neighbors[to], from)
+	}
+
+	position := make(map[int]float64)
+	for _, layer := range layers {
+		for i, n := range layer {
+			position[n] = float64(i)
+		}
+	}
+
+	sortLayer := func(layer []int, around []int) {
+		inAround := make(map[int]bool)
+		for _, n := range around {
+			inAround[n] = true
+		}
+		key := make(map[int]float64)
+		for _, n := range layer {
+			sum, count := 0.0, 0
+			for _, m := range neighbors[n] {
+				if inAround[m] {
+					sum += position[m]
+					count++
+				}
+			}
+			key[n] = position[n]
+			if count > 0 {
+				key[n] = sum / float64(count)
+			}
+		}
+		sort.SliceStable(layer, func(i, j int) bool {
+			return key[layer[i]] < key[layer[j]]
+		})
+		for i, n := range layer {
+			position[n] = float64(i)
+		}
+	}
+
+	for sweep := 0; sweep < graphSweeps; sweep++ {
+		for i := 1; i < len(layers); i++ {
+			sortLayer(layers[i], layers[i-1])
+		}
+		for i := len(layers) - 2; i >= 0; i-- {
+			sortLayer(layers[i], layers[i+1])
+		}
+	}
+}
+
+// edgeBetween draws an edge from the side of a node facing the other one.
+func edgeBetween(from, to layoutNode, edgeType string) layoutEdge {
+	e := layoutEdge{Type: edgeType}
+	switch {
+	case from.layer < to.layer:
+		e.X1, e.Y1 = from.X+from.Width/2, from.Y+from.Height
+		e.X2, e.Y2 = to.X+to.Width/2, to.Y
+	case from.layer > to.layer:
+		e.X1, e.Y1 = from.X+from.Width/2, from.Y
+		e.X2, e.Y2 = to.X+to.Width/2, to.Y+to.Height
+	case from.X < to.X:
+		e.X1, e.Y1 = from.X+from.Width, from.Y+from.Height/2
+		e.X2, e.Y2 = to.X, to.Y+to.Height/2
+	default:
+		e.X1, e.Y1 = from.X, from.Y+from.Height/2
+		e.X2, e.Y2 = to.X+to.Width, to.Y+to.Height/2
+	}
+	return e
+}
+
+// Tooltip is the description of the SwDoc as plain text.
+func (n layoutNode) Tooltip() string {
+	return MarkdownToText(n.Description)
+}
+
+// TextX and TextY are where the name of the SwDoc is centered.
+func (n layoutNode) TextX() int {
+	return n.X + n.Width/2
+}
+
+func (n layoutNode) TextY() int {
+	return n.Y + n.Height/2 + 5
+}
@@ -2,19 +2,107 @@ package swdocs
 
 import (
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"html/template"
 	"io/ioutil"
+	"math"
 	"net/http"
+	"net/url"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	jsonpatch "github.com/evanphx/json-patch/v5"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 )
 
+type swDocPage struct {
+	SwDoc
+	LinkStatus    map[string]LinkStatus
+	RelatedGroups []relatedGroup
+}
+
+// relatedGroup is the list of SwDocs with the same relation to the one shown.
+type relatedGroup struct {
+	Title  string
+	SwDocs []RelatedSwDoc
+}
+
+var relationTitles = map[string]string{
+	RelationDependsOn: "Depends on",
+	RelationUsedBy:    "Used by",
+	RelationSeeAlso:   "See also",
+}
+
+// groupRelated groups the related SwDocs by relation as seen from the SwDoc,
+// the ones relating to it count with the inverse relation.
+func groupRelated(related RelatedSwDocs) []relatedGroup {
+	byType := make(map[string][]RelatedSwDoc)
+	seen := make(map[Relation]bool)
+	add := func(d RelatedSwDoc) {
+		if !seen[d.Relation] {
+			seen[d.Relation] = true
+			byType[d.Type] = append(byType[d.Type], d)
+		}
+	}
+	for _, d := range related.Related {
+		add(d)
+	}
+	for _, d := range related.ReferencedBy {
+		d.Type = inverseRelation(d.Type)
+		add(d)
+	}
+
+	var groups []relatedGroup
+	for _, t := range relationTypes {
+		if len(byType[t]) > 0 {
+			groups = append(groups, relatedGroup{Title: relationTitles[t], SwDocs: byType[t]})
+		}
+	}
+	return groups
+}
+
+type brokenLink struct {
+	Name        string `json:"name"`
+	Section     string `json:"section"`
+	Description string `json:"description"`
+	LinkStatus
+}
+
 type createdAndUpdatedHomePage struct {
 	LastCreated *swDocsSlice
 	LastUpdated *swDocsSlice
+	MostVisited []swDocStats
+	Flash       string
+}
+
+type graphPage struct {
+	// Name is the SwDoc the graph is about, if any.
+	Name   string
+	Depth  int
+	Layout graphLayout
+}
+
+// Deeper and Shallower are the depths to link to from the graph of a SwDoc,
+// Shallower is zero at the smallest depth.
+func (p graphPage) Deeper() int {
+	return p.Depth + 1
+}
+
+func (p graphPage) Shallower() int {
+	return p.Depth - 1
+}
+
+type deletePage struct {
+	SwDoc
+	Links int
+	CSRF  string
+	Error string
 }
 
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
@@ -32,6 +120,21 @@ func respondWithJSONError(w http.ResponseWriter, code int, message string) {
 	respondWithJSON(w, code, map[string]string{"error": message})
 }
 
+// respondWithApplyError responds with 409 if the name or aliases of the SwDoc
+// being applied are taken, with 400 if it is related to SwDocs which don't
+// exist, and with 500 otherwise.
+func respondWithApplyError(w http.ResponseWriter, err error) {
+	if _, ok := err.(conflictError); ok {
+		respondWithJSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if _, ok := err.(ValidationErrors); ok {
+		respondWithJSONError(w, http.StatusBadRequest, "Invalid SwDoc.\n"+err.Error())
+		return
+	}
+	respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+}
+
 func respondWithError(w http.ResponseWriter, code int, message string) {
 	log.WithFields(log.Fields{
 		"code": code,
@@ -39,16 +142,152 @@ func respondWithError(w http.ResponseWriter, code int, message string) {
 	w.WriteHeader(code)
 }
 
+// etag is the entity tag of a SwDoc revision.
+func etag(revision int64) string {
+	return fmt.Sprintf("\"%d\"", revision)
+}
+
+// etagMatches tells whether the If-Match or If-None-Match header value
+// matches the revision, weak comparison is used.
+func etagMatches(header string, revision int64) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag(revision) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch verifies the If-Match precondition of the request against the
+// stored SwDoc called name, responding with 412 and returning false if it fails.
+func (a *App) checkIfMatch(w http.ResponseWriter, r *http.Request, name string) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return true
+	}
+
+	stored, err := getSwDocByName(a.DB, name)
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+
+	if stored.Name == "" || !etagMatches(ifMatch, stored.Revision) {
+		respondWithJSONError(w, http.StatusPreconditionFailed, "SwDoc "+name+" was changed or doesn't exist, its ETag doesn't match If-Match")
+		return false
+	}
+	return true
+}
+
+// redirectStatus is 301 for old names of renamed SwDocs and 302 for aliases,
+// which may be moved to another SwDoc.
+func redirectStatus(permanent bool) int {
+	if permanent {
+		return http.StatusMovedPermanently
+	}
+	return http.StatusFound
+}
+
+// isDryRun tells whether the request asks to only compute the changes without writing them.
+func isDryRun(r *http.Request) bool {
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+	return dryRun
+}
+
+// publishEvents lets the subscribers know new events were committed.
+func (a *App) publishEvents() {
+	if a.webhooks != nil {
+		a.webhooks.Notify()
+	}
+	if a.events != nil {
+		a.events.Notify()
+	}
+}
+
+// applyInTx applies swdoc, and records its event, in a transaction of its own.
+func (a *App) applyInTx(swdoc *SwDoc) error {
+	tx, err := a.DB.Begin()
+	if err != nil {
+		return err
+	}
+	if err := checkRelations(tx, swdoc, nil); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := applySwDoc(tx, swdoc); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	a.publishEvents()
+	return nil
+}
+
+// deleteInTx deletes a SwDoc, and records its event, in a transaction of its own.
+func (a *App) deleteInTx(name, user string) error {
+	tx, err := a.DB.Begin()
+	if err != nil {
+		return err
+	}
+	if err := deleteSwDoc(tx, name, user); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	a.publishEvents()
+	return nil
+}
+
+// purgeTrashInTx deletes for good the SwDocs trashed before the given time in
+// a transaction of its own, and returns their names.
+func (a *App) purgeTrashInTx(before time.Time) ([]string, error) {
+	tx, err := a.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	names, err := purgeTrash(tx, before)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// markStale flags the SwDocs which weren't updated within the configured threshold.
+func (a *App) markStale(docs []SwDoc) {
+	for i := range docs {
+		docs[i].Stale = docs[i].isStale(a.Config.StaleAfter)
+	}
+}
+
 // Templated HTML pages //
 
-func (a *App) homeHandler(w http.ResponseWriter, r *http.Request) {
-	message, err := ioutil.ReadFile(filepath.Join(a.Config.TemplatesPath, "home.gohtml"))
+// templateFuncs are the functions available to the templates.
+var templateFuncs = template.FuncMap{
+	"markdown": renderMarkdown,
+}
+
+// loadTemplate parses a template of the templates directory, they are read on
+// every request so they can be changed without restarting the app. The dates
+// of the page are shown in the timezone of the request.
+func (a *App) loadTemplate(w http.ResponseWriter, r *http.Request, file string) (*template.Template, error) {
+	message, err := ioutil.ReadFile(filepath.Join(a.Config.TemplatesPath, file))
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
-		return
+		return nil, err
 	}
+	return template.New(file).Funcs(templateFuncs).Funcs(timeFuncs(a.timezone(w, r))).Parse(string(message))
+}
 
-	t, err := template.New("HomePage").Parse(string(message))
+func (a *App) homeHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := a.loadTemplate(w, r, "home.gohtml")
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -65,12 +304,23 @@ func (a *App) homeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	mostVisited, err := getMostVisitedSwDocs(a.DB, 10)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	a.markStale(createDocs)
+	a.markStale(updatedDocs)
+
 	c := swDocsSlice{&createDocs}
 	u := swDocsSlice{&updatedDocs}
 
 	h := createdAndUpdatedHomePage{
 		LastCreated: &c,
 		LastUpdated: &u,
+		MostVisited: mostVisited,
+		Flash:       popFlash(w, r),
 	}
 	err = t.Execute(w, h)
 	if err != nil {
@@ -78,21 +328,106 @@ func (a *App) homeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// browseHandler renders the alphabetical index of every SwDoc, ?letter= only
+// lists the ones starting with a letter and ?groupBy= groups them by a label.
+func (a *App) browseHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	letter, ok := parseLetter(query.Get("letter"))
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "letter must be a letter from A to Z or #")
+		return
+	}
+	page := 1
+	if v := query.Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "page must be a positive number")
+			return
+		}
+		page = n
+	}
+
+	t, err := a.loadTemplate(w, r, "all.gohtml")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	docs, err := getAllSwDocs(a.DB)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	a.markStale(docs)
+
+	p := newBrowsePage(docs, letter, query.Get("groupBy"), page)
+	if page > 1 && page > p.Pages {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "There is no page ", page, ", the last one is ", p.Pages)
+		return
+	}
+	if err := t.Execute(w, p); err != nil {
+		log.Error(err.Error())
+	}
+}
+
 func (a *App) swDocHandler(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	swdocName := params["swDocName"]
-	message, err := ioutil.ReadFile(filepath.Join(a.Config.TemplatesPath, "swdoc.gohtml"))
+	t, err := a.loadTemplate(w, r, "swdoc.gohtml")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	doc, err := getSwDocByName(a.DB, swdocName)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if doc.Name == "" {
+		canonical, permanent, err := resolveSwDocName(a.DB, swdocName)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if canonical != "" {
+			http.Redirect(w, r, "/"+url.PathEscape(canonical), redirectStatus(permanent))
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "SwDoc with this name does not exist")
+		return
+	}
+
+	statuses, err := getLinkStatuses(a.DB)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	t, err := template.New("SwDoc").Parse(string(message))
+	related, err := getRelatedSwDocs(a.DB, doc.Name)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	doc.Stale = doc.isStale(a.Config.StaleAfter)
+	err = t.Execute(w, swDocPage{SwDoc: doc, LinkStatus: statuses, RelatedGroups: groupRelated(related)})
+	if err != nil {
+		log.Error(err.Error())
+	}
+}
+
+func (a *App) swDocSlugHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	swdocName := params["swDocName"]
+	slug := params["slug"]
+
 	doc, err := getSwDocByName(a.DB, swdocName)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, err.Error())
@@ -100,26 +435,89 @@ func (a *App) swDocHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if doc.Name == "" {
+		canonical, permanent, err := resolveSwDocName(a.DB, swdocName)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if canonical != "" {
+			http.Redirect(w, r, "/"+url.PathEscape(canonical)+"/"+url.PathEscape(slug), redirectStatus(permanent))
+			return
+		}
+
 		w.WriteHeader(http.StatusNotFound)
 		fmt.Fprint(w, "SwDoc with this name does not exist")
 		return
 	}
 
-	err = t.Execute(w, doc)
-	if err != nil {
-		log.Error(err.Error())
+	l, anchor := doc.findSlug(slug)
+	if l != nil {
+		a.recordLinkClick(doc.Name, *l)
+		http.Redirect(w, r, l.URL, http.StatusFound)
+		return
+	}
+	if anchor != "" {
+		http.Redirect(w, r, "/"+url.PathEscape(doc.Name)+"#"+anchor, http.StatusFound)
+		return
 	}
+
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprint(w, "SwDoc "+doc.Name+" has no link nor section called "+slug)
 }
 
-func (a *App) searchHandler(w http.ResponseWriter, r *http.Request) {
-	searchParams := r.URL.Query().Get("swdocsearch")
-	message, err := ioutil.ReadFile(filepath.Join(a.Config.TemplatesPath, "search.gohtml"))
+func (a *App) goLinkHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	swdocName := params["swDocName"]
+	linkID := params["linkID"]
+
+	doc, err := getSwDocByName(a.DB, swdocName)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	t, err := template.New("Search").Parse(string(message))
+	if doc.Name == "" {
+		canonical, permanent, err := resolveSwDocName(a.DB, swdocName)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if canonical != "" {
+			http.Redirect(w, r, "/go/"+url.PathEscape(canonical)+"/"+url.PathEscape(linkID), redirectStatus(permanent))
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "SwDoc with this name does not exist")
+		return
+	}
+
+	l, ok := doc.findLink(linkID)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "SwDoc "+doc.Name+" has no such link, it may have been changed")
+		return
+	}
+
+	a.recordLinkClick(doc.Name, l)
+	http.Redirect(w, r, l.URL, http.StatusFound)
+}
+
+// recordLinkClick counts a click on a link, only the number of clicks is kept.
+// Failures are logged only, they must not prevent the redirect.
+func (a *App) recordLinkClick(name string, l link) {
+	// Sqlite only allows one writer at a time, handlers that change the state must execute once at a time.
+	a.Mutex.Lock()
+	defer a.Mutex.Unlock()
+
+	if err := recordLinkClick(a.DB, name, l); err != nil {
+		log.Error("Failed to record a click on " + name + ": " + err.Error())
+	}
+}
+
+func (a *App) searchHandler(w http.ResponseWriter, r *http.Request) {
+	searchParams := r.URL.Query().Get("swdocsearch")
+	t, err := a.loadTemplate(w, r, "search.gohtml")
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -138,37 +536,63 @@ func (a *App) searchHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// REST API //
-
-func (a *App) getSwDocsHandler(w http.ResponseWriter, r *http.Request) {
-	queryFilter := r.URL.Query().Get("filter")
-	docs, err := searchSwDocsByName(a.DB, queryFilter)
+// renderSwDocForm renders the create or edit page of a SwDoc.
+func (a *App) renderSwDocForm(w http.ResponseWriter, r *http.Request, code int, form swDocForm) {
+	t, err := a.loadTemplate(w, r, "edit.gohtml")
 	if err != nil {
-		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	respondWithJSON(w, http.StatusOK, docs)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := t.Execute(w, form); err != nil {
+		log.Error(err.Error())
+	}
 }
 
-func (a *App) getSwDocHandler(w http.ResponseWriter, r *http.Request) {
+// newSwDocHandler renders an empty form to create a SwDoc.
+func (a *App) newSwDocHandler(w http.ResponseWriter, r *http.Request) {
+	s := SwDoc{User: formUser(r), Sections: sectionSlice{{Links: linkSlice{{}}}}}
+	a.renderSwDocForm(w, r, http.StatusOK, newSwDocForm(s, true, csrfToken(w, r)))
+}
+
+// editSwDocHandler renders the form to edit a SwDoc, filled with what is stored.
+func (a *App) editSwDocHandler(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	swdocName := params["swDocName"]
 
 	doc, err := getSwDocByName(a.DB, swdocName)
 	if err != nil {
-		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	if doc.Name == "" {
-		respondWithJSONError(w, http.StatusNotFound, "SwDoc with this name does not exist")
+		canonical, permanent, err := resolveSwDocName(a.DB, swdocName)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if canonical != "" {
+			http.Redirect(w, r, "/"+url.PathEscape(canonical)+"/edit", redirectStatus(permanent))
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "SwDoc with this name does not exist")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, doc)
+	if user := formUser(r); user != "" {
+		doc.User = user
+	}
+	a.renderSwDocForm(w, r, http.StatusOK, newSwDocForm(doc, false, csrfToken(w, r)))
 }
 
-func (a *App) deleteSwDocHandler(w http.ResponseWriter, r *http.Request) {
+// submitSwDocHandler handles the create and edit forms. The buttons adding or
+// removing rows render the form again, saving applies the SwDoc like the API.
+func (a *App) submitSwDocHandler(w http.ResponseWriter, r *http.Request) {
 	// Sqlite only allows one writer at a time, handlers that change the state must execute once at a time.
 	a.Mutex.Lock()
 	defer a.Mutex.Unlock()
@@ -176,32 +600,1483 @@ func (a *App) deleteSwDocHandler(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	swdocName := params["swDocName"]
 
-	if err := deleteSwDoc(a.DB, swdocName); err != nil {
-		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid form: "+err.Error())
+		return
+	}
+	if !validCSRF(r) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "The form expired, go back, reload the page and submit it again")
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, nil)
+	s, errs := parseSwDocForm(r)
+	isNew := swdocName == ""
+	if !isNew {
+		// Renaming is a separate operation.
+		s.Name = swdocName
+	}
+
+	action := r.PostForm.Get("action")
+	if action != "save" {
+		applyFormAction(&s, action)
+		form := newSwDocForm(s, isNew, csrfToken(w, r))
+		form.addErrors(errs)
+		a.renderSwDocForm(w, r, http.StatusOK, form)
+		return
+	}
+
+	s.dropEmptyRows()
+	form := newSwDocForm(s, isNew, csrfToken(w, r))
+	if s.User == "" {
+		errs.add("user", "is required")
+	}
+	if err := s.Validate(); err != nil {
+		errs = append(errs, err.(ValidationErrors)...)
+	}
+	if len(errs) > 0 {
+		form.addErrors(errs)
+		a.renderSwDocForm(w, r, http.StatusBadRequest, form)
+		return
+	}
+
+	// The revision the form was filled with works like If-Match.
+	stored, err := getSwDocByName(a.DB, s.Name)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if isNew && stored.Name != "" {
+		form.Errors["name"] = "a SwDoc called " + s.Name + " already exists"
+		a.renderSwDocForm(w, r, http.StatusConflict, form)
+		return
+	}
+	if !isNew && (stored.Name == "" || stored.Revision != s.Revision) {
+		form.Errors[""] = s.Name + " was changed or deleted since you started editing it. Open it in another tab to see what changed, saving again overwrites it."
+		form.Revision = stored.Revision
+		a.renderSwDocForm(w, r, http.StatusConflict, form)
+		return
+	}
+
+	if err := a.applyInTx(&s); err != nil {
+		if _, ok := err.(conflictError); ok {
+			form.Errors[""] = err.Error()
+			a.renderSwDocForm(w, r, http.StatusConflict, form)
+			return
+		}
+		if errs, ok := err.(ValidationErrors); ok {
+			form.addErrors(errs)
+			a.renderSwDocForm(w, r, http.StatusBadRequest, form)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	rememberUser(w, s.User)
+	http.Redirect(w, r, "/"+url.PathEscape(s.Name), http.StatusSeeOther)
 }
 
-func (a *App) applySwDocHandler(w http.ResponseWriter, r *http.Request) {
-	// Sqlite only allows one writer at a time, handlers that change the state must execute once at a time.
-	a.Mutex.Lock()
-	defer a.Mutex.Unlock()
+// renderDeletePage renders the page confirming the deletion of a SwDoc.
+func (a *App) renderDeletePage(w http.ResponseWriter, r *http.Request, code int, doc SwDoc, message string) {
+	t, err := a.loadTemplate(w, r, "delete.gohtml")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 
-	var s SwDoc
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&s); err != nil {
-		respondWithJSONError(w, http.StatusBadRequest, "Invalid request payload.\n"+err.Error())
+	page := deletePage{SwDoc: doc, CSRF: csrfToken(w, r), Error: message}
+	for _, sec := range doc.Sections {
+		page.Links += len(sec.Links)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := t.Execute(w, page); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// deleteSwDocPageHandler asks to confirm the deletion of a SwDoc.
+func (a *App) deleteSwDocPageHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	swdocName := params["swDocName"]
+
+	doc, err := getSwDocByName(a.DB, swdocName)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	defer r.Body.Close()
+	if doc.Name == "" {
+		canonical, permanent, err := resolveSwDocName(a.DB, swdocName)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if canonical != "" {
+			http.Redirect(w, r, "/"+url.PathEscape(canonical)+"/delete", redirectStatus(permanent))
+			return
+		}
 
-	if err := createOrUpdateSwDoc(a.DB, &s); err != nil {
-		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "SwDoc with this name does not exist")
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, s)
+	doc.User = formUser(r)
+	a.renderDeletePage(w, r, http.StatusOK, doc, "")
+}
+
+// submitDeleteSwDocHandler deletes the SwDoc once confirmed. There are no
+// accounts, the user given is trusted and only recorded in the events.
+func (a *App) submitDeleteSwDocHandler(w http.ResponseWriter, r *http.Request) {
+	// Sqlite only allows one writer at a time, handlers that change the state must execute once at a time.
+	a.Mutex.Lock()
+	defer a.Mutex.Unlock()
+
+	params := mux.Vars(r)
+	swdocName := params["swDocName"]
+
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "Invalid form: "+err.Error())
+		return
+	}
+	if !validCSRF(r) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "The form expired, go back, reload the page and submit it again")
+		return
+	}
+
+	doc, err := getSwDocByName(a.DB, swdocName)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if doc.Name == "" {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "SwDoc with this name does not exist")
+		return
+	}
+
+	user := strings.TrimSpace(r.PostForm.Get("user"))
+	doc.User = user
+	if user == "" {
+		a.renderDeletePage(w, r, http.StatusBadRequest, doc, "Your user is required to delete a SwDoc.")
+		return
+	}
+	// The revision the page was rendered with works like If-Match.
+	if revision, _ := strconv.ParseInt(r.PostForm.Get("revision"), 10, 64); revision != doc.Revision {
+		a.renderDeletePage(w, r, http.StatusConflict, doc, swdocName+" was changed since you opened this page, check it is still to be deleted.")
+		return
+	}
+
+	if err := a.deleteInTx(swdocName, user); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	rememberUser(w, user)
+	setFlash(w, swdocName+" was moved to the trash.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// userActivityLength is how many of their latest changes the page of a user shows.
+const userActivityLength = 30
+
+// userActivity returns the SwDocs last updated by user and their latest changes.
+func (a *App) userActivity(user string) (UserActivity, error) {
+	activity := UserActivity{User: user, SwDocs: []SwDoc{}, Events: []Event{}}
+	docs, err := getUserSwDocs(a.DB, user)
+	if err != nil {
+		return activity, err
+	}
+	events, err := getUserEvents(a.DB, user, userActivityLength)
+	if err != nil {
+		return activity, err
+	}
+
+	a.markStale(docs)
+	activity.SwDocs = append(activity.SwDocs, docs...)
+	activity.Events = append(activity.Events, events...)
+	return activity, nil
+}
+
+// teamSwDocs returns the SwDocs owned by team with their broken links.
+func (a *App) teamSwDocs(team string) (TeamSwDocs, error) {
+	t := TeamSwDocs{Team: team, SwDocs: []TeamSwDoc{}}
+	docs, err := getAllSwDocs(a.DB)
+	if err != nil {
+		return t, err
+	}
+	sections, err := getAllSwDocSections(a.DB)
+	if err != nil {
+		return t, err
+	}
+	statuses, err := getLinkStatuses(a.DB)
+	if err != nil {
+		return t, err
+	}
+
+	broken := make(map[string]int)
+	for _, doc := range sections {
+		for _, sec := range doc.Sections {
+			for _, l := range sec.Links {
+				if status, ok := statuses[l.URL]; ok && status.Broken() {
+					broken[doc.Name]++
+				}
+			}
+		}
+	}
+
+	a.markStale(docs)
+	for _, doc := range docs {
+		if doc.Labels[ownerLabel] != team {
+			continue
+		}
+		t.SwDocs = append(t.SwDocs, TeamSwDoc{SwDoc: doc, BrokenLinks: broken[doc.Name]})
+		t.BrokenLinks += broken[doc.Name]
+		if doc.Stale {
+			t.Stale++
+		}
+	}
+	return t, nil
+}
+
+// userHandler renders the SwDocs last updated by a user and their latest changes.
+func (a *App) userHandler(w http.ResponseWriter, r *http.Request) {
+	user := mux.Vars(r)["user"]
+	t, err := a.loadTemplate(w, r, "user.gohtml")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	activity, err := a.userActivity(user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(activity.SwDocs) == 0 && len(activity.Events) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, user+" hasn't changed any SwDoc")
+		return
+	}
+
+	if err := t.Execute(w, activity); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// teamHandler renders the SwDocs owned by a team, as set by their owner label.
+func (a *App) teamHandler(w http.ResponseWriter, r *http.Request) {
+	team := mux.Vars(r)["team"]
+	t, err := a.loadTemplate(w, r, "team.gohtml")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	docs, err := a.teamSwDocs(team)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(docs.SwDocs) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "No SwDoc is owned by "+team)
+		return
+	}
+
+	if err := t.Execute(w, docs); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// defaultGraphDepth is how many relations away from a SwDoc its graph goes.
+const defaultGraphDepth = 2
+
+// graphDepth reads ?depth, how many relations away from a SwDoc its graph goes.
+func graphDepth(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("depth")
+	if v == "" {
+		return defaultGraphDepth, nil
+	}
+	depth, err := strconv.Atoi(v)
+	if err != nil || depth < 1 {
+		return 0, errors.New("depth must be a positive number")
+	}
+	return depth, nil
+}
+
+// graphFor returns the graph of the SwDocs with relations or, when name is
+// given, the part of it up to depth relations away from that SwDoc.
+func (a *App) graphFor(name string, depth int) (Graph, error) {
+	g, err := getGraph(a.DB)
+	if err != nil {
+		return g, err
+	}
+	if name == "" {
+		return g.connected(), nil
+	}
+	return g.neighborhood(name, depth), nil
+}
+
+// graphHandler renders the graph of the relations between SwDocs as SVG, the
+// one of /{swDocName}/graph is limited to the SwDocs around it.
+func (a *App) graphHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	swdocName := params["swDocName"]
+
+	if swdocName != "" {
+		exists, err := swDocExists(a.DB, swdocName)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !exists {
+			canonical, permanent, err := resolveSwDocName(a.DB, swdocName)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if canonical != "" {
+				http.Redirect(w, r, "/"+url.PathEscape(canonical)+"/graph", redirectStatus(permanent))
+				return
+			}
+
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "SwDoc with this name does not exist")
+			return
+		}
+	}
+
+	depth, err := graphDepth(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	g, err := a.graphFor(swdocName, depth)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	t, err := a.loadTemplate(w, r, "graph.gohtml")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	page := graphPage{Name: swdocName, Depth: depth, Layout: g.layout(swdocName)}
+	if err := t.Execute(w, page); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// getGraphHandler serves the graph of the relations between SwDocs as JSON
+// or, with ?format=dot, in the Graphviz DOT language. ?name= limits it to
+// the SwDocs up to ?depth relations away from one.
+func (a *App) getGraphHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "dot" {
+		respondWithJSONError(w, http.StatusBadRequest, "Unsupported format, options are 'json' and 'dot'")
+		return
+	}
+
+	if name != "" {
+		exists, err := swDocExists(a.DB, name)
+		if err != nil {
+			respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !exists {
+			respondWithJSONError(w, http.StatusNotFound, "SwDoc with this name does not exist")
+			return
+		}
+	}
+
+	depth, err := graphDepth(r)
+	if err != nil {
+		respondWithJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	g, err := a.graphFor(name, depth)
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if format == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		fmt.Fprint(w, g.DOT())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, g)
+}
+
+// feedHandler serves the recent changes as an Atom or RSS feed, either of the
+// SwDocs matching ?selector= or of a single SwDoc.
+func (a *App) feedHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	swdocName := params["swDocName"]
+	format := params["format"]
+	baseURL := requestBaseURL(r)
+
+	var events []Event
+	title := "swdocs changes"
+	if swdocName != "" {
+		doc, err := getSwDocByName(a.DB, swdocName)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if doc.Name == "" {
+			canonical, permanent, err := resolveSwDocName(a.DB, swdocName)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if canonical != "" {
+				http.Redirect(w, r, "/"+url.PathEscape(canonical)+"/feed."+format, redirectStatus(permanent))
+				return
+			}
+		}
+
+		// Deleted SwDocs still have a history.
+		events, err = getSwDocEvents(a.DB, swdocName, feedLength)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if len(events) == 0 && doc.Name != "" {
+			// SwDocs not changed since before events were recorded.
+			events = []Event{storedSwDocEvent(doc)}
+		}
+		if len(events) == 0 {
+			trashed, err := isTrashed(a.DB, swdocName)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if !trashed {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, "SwDoc with this name does not exist")
+				return
+			}
+		}
+		title += " of " + swdocName
+	} else {
+		selector, err := ParseSelector(r.URL.Query().Get("selector"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, err.Error())
+			return
+		}
+		if len(selector) > 0 {
+			title += " of " + r.URL.Query().Get("selector")
+		}
+
+		before := int64(math.MaxInt64)
+		for len(events) < feedLength {
+			page, err := getEventsBefore(a.DB, before, feedLength)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if len(page) == 0 {
+				break
+			}
+			for _, e := range page {
+				before = e.ID
+				if e.matches(selector) && len(events) < feedLength {
+					events = append(events, e)
+				}
+			}
+		}
+
+		// SwDocs not changed since before events were recorded come last.
+		if len(events) < feedLength {
+			docs, err := getSwDocsWithoutEvents(a.DB)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			for _, doc := range docs {
+				if e := storedSwDocEvent(doc); e.matches(selector) && len(events) < feedLength {
+					events = append(events, e)
+				}
+			}
+		}
+	}
+
+	var feed interface{}
+	contentType := "application/rss+xml; charset=utf-8"
+	if format == "atom" {
+		feed = buildAtomFeed(baseURL, baseURL+r.URL.RequestURI(), title, events)
+		contentType = "application/atom+xml; charset=utf-8"
+	} else {
+		feed = buildRSSFeed(baseURL, title, events)
+	}
+
+	out, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write([]byte(xml.Header))
+	w.Write(out)
+}
+
+// REST API //
+
+func (a *App) getSwDocsHandler(w http.ResponseWriter, r *http.Request) {
+	queryFilter := r.URL.Query().Get("filter")
+	selector, err := ParseSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		respondWithJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	docs, err := searchSwDocsByName(a.DB, queryFilter)
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	docs = filterSwDocs(docs, selector)
+	a.markStale(docs)
+	respondWithJSON(w, http.StatusOK, docs)
+}
+
+func (a *App) getSwDocHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	swdocName := params["swDocName"]
+
+	doc, err := getSwDocByName(a.DB, swdocName)
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if doc.Name == "" {
+		canonical, permanent, err := resolveSwDocName(a.DB, swdocName)
+		if err != nil {
+			respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if canonical != "" {
+			http.Redirect(w, r, "/api/v1/swdocs/"+url.PathEscape(canonical), redirectStatus(permanent))
+			return
+		}
+
+		respondWithJSONError(w, http.StatusNotFound, "SwDoc with this name does not exist")
+		return
+	}
+
+	w.Header().Set("ETag", etag(doc.Revision))
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, doc.Revision) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	doc.Stale = doc.isStale(a.Config.StaleAfter)
+	respondWithJSON(w, http.StatusOK, doc)
+}
+
+func (a *App) getSwDocStatsHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	swdocName := params["swDocName"]
+
+	doc, err := getSwDocByName(a.DB, swdocName)
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if doc.Name == "" {
+		respondWithJSONError(w, http.StatusNotFound, "SwDoc with this name does not exist")
+		return
+	}
+
+	clicks, err := getLinkClicks(a.DB, doc.Name)
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	stats := swDocStats{Name: doc.Name, Links: []linkStats{}}
+	for _, sec := range doc.Sections {
+		for _, l := range sec.Links {
+			c := clicks[l.ID()]
+			stats.Clicks += c.Clicks
+			stats.Links = append(stats.Links, linkStats{
+				ID:          l.ID(),
+				Section:     sec.Header,
+				Description: l.Description,
+				URL:         l.URL,
+				Clicks:      c.Clicks,
+				LastClicked: c.LastClicked,
+			})
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, stats)
+}
+
+// getRelatedSwDocsHandler lists the SwDocs related to one, both ways.
+func (a *App) getRelatedSwDocsHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	swdocName := params["swDocName"]
+
+	exists, err := swDocExists(a.DB, swdocName)
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !exists {
+		respondWithJSONError(w, http.StatusNotFound, "SwDoc with this name does not exist")
+		return
+	}
+
+	related, err := getRelatedSwDocs(a.DB, swdocName)
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, related)
+}
+
+func (a *App) getStatsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+
+	stats, err := getMostVisitedSwDocs(a.DB, limit)
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, stats)
+}
+
+// getUserHandler serves the SwDocs last updated by a user and their latest changes.
+func (a *App) getUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := mux.Vars(r)["user"]
+	activity, err := a.userActivity(user)
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(activity.SwDocs) == 0 && len(activity.Events) == 0 {
+		respondWithJSONError(w, http.StatusNotFound, user+" hasn't changed any SwDoc")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, activity)
+}
+
+// getTeamHandler serves the SwDocs owned by a team with their stale and broken link counts.
+func (a *App) getTeamHandler(w http.ResponseWriter, r *http.Request) {
+	team := mux.Vars(r)["team"]
+	docs, err := a.teamSwDocs(team)
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(docs.SwDocs) == 0 {
+		respondWithJSONError(w, http.StatusNotFound, "No SwDoc is owned by "+team)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, docs)
+}
+
+func (a *App) getBrokenLinksHandler(w http.ResponseWriter, r *http.Request) {
+	docs, err := getAllSwDocSections(a.DB)
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	statuses, err := getLinkStatuses(a.DB)
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	broken := []brokenLink{}
+	for _, doc := range docs {
+		for _, sec := range doc.Sections {
+			for _, l := range sec.Links {
+				if status, ok := statuses[l.URL]; ok && status.Broken() {
+					broken = append(broken, brokenLink{
+						Name:        doc.Name,
+						Section:     sec.Header,
+						Description: l.Description,
+						LinkStatus:  status,
+					})
+				}
+			}
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, broken)
+}
+
+// getStaleReportHandler lists the SwDocs not updated for ?days, or the
+// configured threshold, grouped by their owner.
+func (a *App) getStaleReportHandler(w http.ResponseWriter, r *http.Request) {
+	after := a.Config.StaleAfter
+	if v := r.URL.Query().Get("days"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days <= 0 {
+			respondWithJSONError(w, http.StatusBadRequest, "days must be a positive number")
+			return
+		}
+		after = time.Duration(days) * 24 * time.Hour
+	}
+	if after <= 0 {
+		respondWithJSONError(w, http.StatusBadRequest, "Staleness is disabled, give the number of days")
+		return
+	}
+
+	selector, err := ParseSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		respondWithJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	docs, err := getStaleSwDocs(a.DB, time.Now().Add(-after))
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	groups := []StaleGroup{}
+	byOwner := make(map[string]int)
+	for _, doc := range filterSwDocs(docs, selector) {
+		owner := doc.Owner()
+		i, ok := byOwner[owner]
+		if !ok {
+			i = len(groups)
+			byOwner[owner] = i
+			groups = append(groups, StaleGroup{Owner: owner})
+		}
+		groups[i].SwDocs = append(groups[i].SwDocs, doc)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Owner < groups[j].Owner
+	})
+
+	respondWithJSON(w, http.StatusOK, groups)
+}
+
+func (a *App) deleteSwDocHandler(w http.ResponseWriter, r *http.Request) {
+	// Sqlite only allows one writer at a time, handlers that change the state must execute once at a time.
+	a.Mutex.Lock()
+	defer a.Mutex.Unlock()
+
+	params := mux.Vars(r)
+	swdocName := params["swDocName"]
+
+	if !a.checkIfMatch(w, r, swdocName) {
+		return
+	}
+
+	if err := a.deleteInTx(swdocName, r.URL.Query().Get("user")); err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, nil)
+}
+
+// getTrashHandler lists the deleted SwDocs which can still be restored.
+func (a *App) getTrashHandler(w http.ResponseWriter, r *http.Request) {
+	docs, err := getTrash(a.DB)
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, docs)
+}
+
+// restoreSwDocHandler takes a SwDoc out of the trash on behalf of ?user=.
+func (a *App) restoreSwDocHandler(w http.ResponseWriter, r *http.Request) {
+	// Sqlite only allows one writer at a time, handlers that change the state must execute once at a time.
+	a.Mutex.Lock()
+	defer a.Mutex.Unlock()
+
+	params := mux.Vars(r)
+	swdocName := params["swDocName"]
+
+	trashed, err := isTrashed(a.DB, swdocName)
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !trashed {
+		respondWithJSONError(w, http.StatusNotFound, "SwDoc with this name is not in the trash")
+		return
+	}
+
+	tx, err := a.DB.Begin()
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := restoreSwDoc(tx, swdocName, r.URL.Query().Get("user")); err != nil {
+		tx.Rollback()
+		respondWithApplyError(w, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	a.publishEvents()
+
+	s, err := getSwDocByName(a.DB, swdocName)
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("ETag", etag(s.Revision))
+	respondWithJSON(w, http.StatusOK, s)
+}
+
+// purgeSwDocHandler deletes a SwDoc of the trash for good.
+func (a *App) purgeSwDocHandler(w http.ResponseWriter, r *http.Request) {
+	// Sqlite only allows one writer at a time, handlers that change the state must execute once at a time.
+	a.Mutex.Lock()
+	defer a.Mutex.Unlock()
+
+	params := mux.Vars(r)
+	swdocName := params["swDocName"]
+
+	tx, err := a.DB.Begin()
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	trashed, err := isTrashed(tx, swdocName)
+	if err != nil {
+		tx.Rollback()
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !trashed {
+		tx.Rollback()
+		respondWithJSONError(w, http.StatusNotFound, "SwDoc with this name is not in the trash")
+		return
+	}
+	if err := purgeSwDoc(tx, swdocName); err != nil {
+		tx.Rollback()
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, nil)
+}
+
+// emptyTrashHandler deletes for good the SwDocs in the trash for longer than
+// ?days, or all of them, and responds with their names.
+func (a *App) emptyTrashHandler(w http.ResponseWriter, r *http.Request) {
+	// Sqlite only allows one writer at a time, handlers that change the state must execute once at a time.
+	a.Mutex.Lock()
+	defer a.Mutex.Unlock()
+
+	before := time.Now()
+	if v := r.URL.Query().Get("days"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 0 {
+			respondWithJSONError(w, http.StatusBadRequest, "days must be a positive number")
+			return
+		}
+		before = before.Add(-time.Duration(days) * 24 * time.Hour)
+	}
+
+	names, err := a.purgeTrashInTx(before)
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if names == nil {
+		names = []string{}
+	}
+	respondWithJSON(w, http.StatusOK, names)
+}
+
+func (a *App) applySwDocHandler(w http.ResponseWriter, r *http.Request) {
+	// Sqlite only allows one writer at a time, handlers that change the state must execute once at a time.
+	a.Mutex.Lock()
+	defer a.Mutex.Unlock()
+
+	var s SwDoc
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&s); err != nil {
+		respondWithJSONError(w, http.StatusBadRequest, "Invalid request payload.\n"+err.Error())
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := s.Validate(); err != nil {
+		respondWithJSONError(w, http.StatusBadRequest, "Invalid SwDoc.\n"+err.Error())
+		return
+	}
+
+	if !a.checkIfMatch(w, r, s.Name) {
+		return
+	}
+
+	if isDryRun(r) {
+		if err := checkRelations(a.DB, &s, nil); err != nil {
+			respondWithApplyError(w, err)
+			return
+		}
+		result, err := planSwDoc(a.DB, &s)
+		if err != nil {
+			respondWithApplyError(w, err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, result)
+		return
+	}
+
+	if err := a.applyInTx(&s); err != nil {
+		respondWithApplyError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(s.Revision))
+	respondWithJSON(w, http.StatusCreated, s)
+}
+
+func (a *App) applySwDocsBatchHandler(w http.ResponseWriter, r *http.Request) {
+	// Sqlite only allows one writer at a time, handlers that change the state must execute once at a time.
+	a.Mutex.Lock()
+	defer a.Mutex.Unlock()
+
+	var docs []SwDoc
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&docs); err != nil {
+		respondWithJSONError(w, http.StatusBadRequest, "Invalid request payload, expected a list of SwDocs.\n"+err.Error())
+		return
+	}
+
+	defer r.Body.Close()
+
+	// Validate everything upfront, the batch is applied all or nothing.
+	results := make([]ApplyResult, len(docs))
+	seen := make(map[string]bool)
+	valid := true
+	for i := range docs {
+		results[i].Name = docs[i].Name
+		if err := docs[i].Validate(); err != nil {
+			results[i].Error = err.Error()
+			valid = false
+		} else if seen[docs[i].Name] {
+			results[i].Error = "SwDoc is repeated in the batch"
+			valid = false
+		}
+		seen[docs[i].Name] = true
+	}
+
+	// The SwDocs can be related to the ones created along with them.
+	for i := range docs {
+		if results[i].Error != "" {
+			continue
+		}
+		if err := checkRelations(a.DB, &docs[i], seen); err != nil {
+			if _, ok := err.(ValidationErrors); !ok {
+				respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			results[i].Error = err.Error()
+			valid = false
+		}
+	}
+
+	if !valid {
+		log.WithFields(log.Fields{
+			"code": http.StatusBadRequest,
+		}).Error("Invalid SwDocs in batch")
+		respondWithJSON(w, http.StatusBadRequest, results)
+		return
+	}
+
+	if isDryRun(r) {
+		a.planBatch(w, docs, results)
+		return
+	}
+
+	tx, err := a.DB.Begin()
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	for i := range docs {
+		action, err := applySwDoc(tx, &docs[i])
+		if _, ok := err.(conflictError); ok {
+			tx.Rollback()
+			for j := range results {
+				results[j].Action = ""
+			}
+			results[i].Error = err.Error()
+			respondWithJSON(w, http.StatusConflict, results)
+			return
+		} else if err != nil {
+			tx.Rollback()
+			respondWithJSONError(w, http.StatusInternalServerError, "Failed to apply "+docs[i].Name+", nothing was applied.\n"+err.Error())
+			return
+		}
+		results[i].Action = action
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	a.publishEvents()
+
+	respondWithJSON(w, http.StatusOK, results)
+}
+
+// planBatch responds with what applying the batch would do. Each SwDoc is
+// applied after being planned, in a transaction which is rolled back, so the
+// conflicts between the SwDocs of the batch are found as well.
+func (a *App) planBatch(w http.ResponseWriter, docs []SwDoc, results []ApplyResult) {
+	tx, err := a.DB.Begin()
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer tx.Rollback()
+
+	for i := range docs {
+		result, err := planSwDoc(tx, &docs[i])
+		if err == nil {
+			_, err = applySwDoc(tx, &docs[i])
+		}
+		if _, ok := err.(conflictError); ok {
+			results[i].Error = err.Error()
+			respondWithJSON(w, http.StatusConflict, results)
+			return
+		} else if err != nil {
+			respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		results[i] = result
+	}
+	respondWithJSON(w, http.StatusOK, results)
+}
+
+func (a *App) patchSwDocHandler(w http.ResponseWriter, r *http.Request) {
+	// Sqlite only allows one writer at a time, handlers that change the state must execute once at a time.
+	a.Mutex.Lock()
+	defer a.Mutex.Unlock()
+
+	params := mux.Vars(r)
+	swdocName := params["swDocName"]
+
+	if !a.checkIfMatch(w, r, swdocName) {
+		return
+	}
+
+	stored, err := getSwDocByName(a.DB, swdocName)
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if stored.Name == "" {
+		respondWithJSONError(w, http.StatusNotFound, "SwDoc with this name does not exist")
+		return
+	}
+
+	patch, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		respondWithJSONError(w, http.StatusBadRequest, "Invalid request payload.\n"+err.Error())
+		return
+	}
+
+	defer r.Body.Close()
+
+	s, err := patchSwDoc(stored, r.Header.Get("Content-Type"), patch)
+	if err == errUnsupportedPatch {
+		respondWithJSONError(w, http.StatusUnsupportedMediaType, err.Error())
+		return
+	} else if errors.Is(err, jsonpatch.ErrTestFailed) {
+		respondWithJSONError(w, http.StatusConflict, "Patch not applied.\n"+err.Error())
+		return
+	} else if err != nil {
+		respondWithJSONError(w, http.StatusBadRequest, "Invalid patch.\n"+err.Error())
+		return
+	}
+
+	if s.Name != stored.Name {
+		respondWithJSONError(w, http.StatusBadRequest, "The name of a SwDoc can't be patched")
+		return
+	}
+
+	if err := s.Validate(); err != nil {
+		respondWithJSONError(w, http.StatusBadRequest, "Invalid SwDoc.\n"+err.Error())
+		return
+	}
+
+	if isDryRun(r) {
+		if err := checkRelations(a.DB, &s, nil); err != nil {
+			respondWithApplyError(w, err)
+			return
+		}
+		result, err := planSwDoc(a.DB, &s)
+		if err != nil {
+			respondWithApplyError(w, err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, result)
+		return
+	}
+
+	if err := a.applyInTx(&s); err != nil {
+		respondWithApplyError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(s.Revision))
+	respondWithJSON(w, http.StatusOK, s)
+}
+
+func (a *App) renameSwDocHandler(w http.ResponseWriter, r *http.Request) {
+	// Sqlite only allows one writer at a time, handlers that change the state must execute once at a time.
+	a.Mutex.Lock()
+	defer a.Mutex.Unlock()
+
+	params := mux.Vars(r)
+	swdocName := params["swDocName"]
+
+	var req RenameRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		respondWithJSONError(w, http.StatusBadRequest, "Invalid request payload.\n"+err.Error())
+		return
+	}
+
+	defer r.Body.Close()
+
+	if !a.checkIfMatch(w, r, swdocName) {
+		return
+	}
+
+	s, err := getSwDocByName(a.DB, swdocName)
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if s.Name == "" {
+		respondWithJSONError(w, http.StatusNotFound, "SwDoc with this name does not exist")
+		return
+	}
+
+	if req.Name == s.Name {
+		respondWithJSONError(w, http.StatusBadRequest, "SwDoc is already called "+req.Name)
+		return
+	}
+
+	s.Name = req.Name
+	var aliases aliasList
+	for _, alias := range s.Aliases {
+		// Renaming to one of its aliases drops the alias.
+		if alias != s.Name {
+			aliases = append(aliases, alias)
+		}
+	}
+	s.Aliases = aliases
+	if req.User != "" {
+		s.User = req.User
+	}
+	if err := s.Validate(); err != nil {
+		respondWithJSONError(w, http.StatusBadRequest, "Invalid SwDoc.\n"+err.Error())
+		return
+	}
+
+	exists, err := swDocExists(a.DB, s.Name)
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if exists {
+		respondWithJSONError(w, http.StatusConflict, "A SwDoc called "+s.Name+" already exists")
+		return
+	}
+
+	trashed, err := isTrashed(a.DB, s.Name)
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if trashed {
+		respondWithJSONError(w, http.StatusConflict, "A SwDoc called "+s.Name+" is in the trash, purge it first")
+		return
+	}
+
+	owner, err := getAliasOwner(a.DB, s.Name)
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if owner != "" && owner != swdocName {
+		respondWithJSONError(w, http.StatusConflict, s.Name+" is already an alias of "+owner)
+		return
+	}
+
+	tx, err := a.DB.Begin()
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := renameSwDoc(tx, swdocName, s.Name, s.User); err != nil {
+		tx.Rollback()
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	a.publishEvents()
+
+	s, err = getSwDocByName(a.DB, s.Name)
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("ETag", etag(s.Revision))
+	respondWithJSON(w, http.StatusOK, s)
+}
+
+// eventsHeartbeat is how often a comment is sent on idle event streams, so
+// proxies don't close them.
+const eventsHeartbeat = 15 * time.Second
+
+// eventsHandler streams the events as Server-Sent Events, ?selector= filters
+// them by labels. Clients resume after the last event they got with the
+// Last-Event-ID header, or ?lastEventId=, and get the events they missed.
+func (a *App) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithJSONError(w, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+
+	selector, err := ParseSelector(r.URL.Query().Get("selector"))
+	if err != nil {
+		respondWithJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+	var sent int64
+	if lastEventID != "" {
+		if sent, err = strconv.ParseInt(lastEventID, 10, 64); err != nil {
+			respondWithJSONError(w, http.StatusBadRequest, "Invalid Last-Event-ID "+lastEventID)
+			return
+		}
+	}
+
+	// Subscribe before catching up so no event is missed in between, the
+	// events received twice are skipped by their id.
+	ch := a.events.subscribe()
+	defer a.events.unsubscribe(ch)
+
+	if lastEventID == "" {
+		// Only the events from now on are sent.
+		if sent, err = getLastEventID(a.DB); err != nil {
+			respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	if lastEventID != "" {
+		for {
+			events, err := getEventsAfter(a.DB, sent, 100)
+			if err != nil {
+				log.Error("Failed to read the events to catch up: " + err.Error())
+				return
+			}
+			if len(events) == 0 {
+				break
+			}
+			for _, e := range events {
+				sent = e.ID
+				if e.matches(selector) {
+					if err := writeEvent(w, e); err != nil {
+						return
+					}
+				}
+			}
+		}
+	}
+	// The id of the last event the stream is past, so a client disconnecting
+	// before getting any event, or only events it filters out, resumes from here.
+	fmt.Fprintf(w, "id: %d\n\n", sent)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case e, ok := <-ch:
+			if !ok {
+				// Fell behind, the client reconnects and catches up.
+				return
+			}
+			if e.ID <= sent {
+				continue
+			}
+			sent = e.ID
+			if e.matches(selector) {
+				if err := writeEvent(w, e); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// webhookID is the id in the route of a webhook, the route only matches digits.
+func webhookID(r *http.Request) int64 {
+	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	return id
+}
+
+func (a *App) getWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	hooks, err := getWebhooks(a.DB)
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Secrets are write only.
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	if hooks == nil {
+		hooks = []Webhook{}
+	}
+	respondWithJSON(w, http.StatusOK, hooks)
+}
+
+func (a *App) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	// Sqlite only allows one writer at a time, handlers that change the state must execute once at a time.
+	a.Mutex.Lock()
+	defer a.Mutex.Unlock()
+
+	var hook Webhook
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&hook); err != nil {
+		respondWithJSONError(w, http.StatusBadRequest, "Invalid request payload.\n"+err.Error())
+		return
+	}
+
+	defer r.Body.Close()
+
+	if err := hook.Validate(); err != nil {
+		respondWithJSONError(w, http.StatusBadRequest, "Invalid webhook.\n"+err.Error())
+		return
+	}
+
+	if err := createWebhook(a.DB, &hook); err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	hook.Secret = ""
+	respondWithJSON(w, http.StatusCreated, hook)
+}
+
+func (a *App) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	// Sqlite only allows one writer at a time, handlers that change the state must execute once at a time.
+	a.Mutex.Lock()
+	defer a.Mutex.Unlock()
+
+	if err := deleteWebhook(a.DB, webhookID(r)); err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, nil)
+}
+
+func (a *App) getWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	hook, err := getWebhook(a.DB, webhookID(r))
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if hook.ID == 0 {
+		respondWithJSONError(w, http.StatusNotFound, "Webhook with this id does not exist")
+		return
+	}
+
+	deliveries, err := getDeliveries(a.DB, hook.ID, deliveriesKept)
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, deliveries)
+}
+
+// pingWebhookHandler sends a ping event to the webhook, once, and responds with how it went.
+func (a *App) pingWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	hook, err := getWebhook(a.DB, webhookID(r))
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if hook.ID == 0 {
+		respondWithJSONError(w, http.StatusNotFound, "Webhook with this id does not exist")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, a.webhooks.Send(hook, Event{Type: EventPing}, 1))
 }
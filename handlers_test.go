@@ -0,0 +1,216 @@
+package swdocs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveTestRequest sends a request to the routes of the app.
+func serveTestRequest(a *App, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	a.Router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestBatchDryRunFindsConflictsWithinTheBatch(t *testing.T) {
+	a := newTestApp(t)
+
+	batch := `[
+		{"name": "rabbitmq", "description": "A broker", "user": "test", "aliases": ["mq"]},
+		{"name": "mq", "description": "Another broker", "user": "test"}
+	]`
+	rec := serveTestRequest(a, "POST", "/api/v1/swdocs/batch?dryRun=true", batch)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusConflict, rec.Body)
+	}
+	var results []ApplyResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[1].Error == "" {
+		t.Errorf("got results %+v, want a conflict on mq", results)
+	}
+
+	rec = serveTestRequest(a, "POST", "/api/v1/swdocs/batch", batch)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("got status %d applying the batch, want %d as the dry run", rec.Code, http.StatusConflict)
+	}
+}
+
+func TestBatchDryRunWritesNothing(t *testing.T) {
+	a := newTestApp(t)
+
+	batch := `[
+		{"name": "rabbitmq", "description": "A broker", "user": "test", "aliases": ["mq"]},
+		{"name": "consumer", "description": "Reads the queue", "user": "test", "related": [{"name": "rabbitmq", "type": "depends-on"}]}
+	]`
+	rec := serveTestRequest(a, "POST", "/api/v1/swdocs/batch?dryRun=true", batch)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var results []ApplyResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if result.Action != ActionCreated {
+			t.Errorf("got action %q for %s, want %q", result.Action, result.Name, ActionCreated)
+		}
+	}
+
+	for _, name := range []string{"rabbitmq", "consumer"} {
+		if exists, err := swDocExists(a.DB, name); err != nil || exists {
+			t.Errorf("%s was stored by the dry run", name)
+		}
+	}
+	if events := testEvents(t, a); len(events) != 0 {
+		t.Errorf("got %d events recorded by the dry run, want none", len(events))
+	}
+}
+
+func TestApplyingAnUnchangedSwDocKeepsItsRevision(t *testing.T) {
+	a := newTestApp(t)
+
+	doc := `{"name": "rabbitmq", "description": "A broker", "user": "test", "labels": {"team": "infra"}, "aliases": ["mq"],
+		"sections": [{"header": "Links", "links": [{"url": "https://www.rabbitmq.com", "description": "Website"}]}]}`
+	first := serveTestRequest(a, "POST", "/api/v1/swdocs/apply", doc)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", first.Code, http.StatusCreated, first.Body)
+	}
+	second := serveTestRequest(a, "POST", "/api/v1/swdocs/apply", doc)
+	if second.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d: %s", second.Code, http.StatusCreated, second.Body)
+	}
+	if etag := second.Header().Get("ETag"); etag != first.Header().Get("ETag") {
+		t.Errorf("got ETag %s, want it unchanged from %s", etag, first.Header().Get("ETag"))
+	}
+
+	stored, err := getSwDocByName(a.DB, "rabbitmq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Revision != 1 {
+		t.Errorf("got revision %d, want 1", stored.Revision)
+	}
+	if events := testEvents(t, a); len(events) != 1 {
+		t.Errorf("got %d events, want only the creation", len(events))
+	}
+
+	rec := serveTestRequest(a, "POST", "/api/v1/swdocs/batch", "["+doc+"]")
+	var results []ApplyResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Action != ActionUnchanged {
+		t.Errorf("got results %+v, want rabbitmq unchanged", results)
+	}
+
+	changed := strings.Replace(doc, "A broker", "A message broker", 1)
+	serveTestRequest(a, "POST", "/api/v1/swdocs/apply", changed)
+	if stored, _ := getSwDocByName(a.DB, "rabbitmq"); stored.Revision != 2 {
+		t.Errorf("got revision %d after a change, want 2", stored.Revision)
+	}
+}
+
+func TestEventStreamStartsWithTheLastEventID(t *testing.T) {
+	a := newTestApp(t)
+	applyTestSwDoc(t, a, SwDoc{Name: "rabbitmq", Description: "A broker"})
+	applyTestSwDoc(t, a, SwDoc{Name: "kafka", Description: "A log"})
+
+	server := httptest.NewServer(a.Router)
+	defer server.Close()
+
+	tests := []struct {
+		lastEventID string
+		want        []string
+	}{
+		{"", []string{"id: 2"}},
+		{"1", []string{"id: 2", "event: created", "id: 2"}},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/api/v1/events", nil)
+		if tt.lastEventID != "" {
+			req.Header.Set("Last-Event-ID", tt.lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The stream stays open, read it up to the id it is past.
+		var got []string
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() && len(got) < len(tt.want) {
+			line := scanner.Text()
+			if strings.HasPrefix(line, "id:") || strings.HasPrefix(line, "event:") {
+				got = append(got, line)
+			}
+		}
+		cancel()
+		resp.Body.Close()
+
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("Last-Event-ID %q: got %q, want %q", tt.lastEventID, got, tt.want)
+		}
+	}
+}
+
+func TestApplyingATrashedNameIsRefused(t *testing.T) {
+	a := newTestApp(t)
+	applyTestSwDoc(t, a, SwDoc{Name: "rabbitmq", Description: "A broker"})
+	if rec := serveTestRequest(a, "DELETE", "/api/v1/swdocs/rabbitmq?user=test", ""); rec.Code != http.StatusOK {
+		t.Fatalf("got status %d deleting, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	doc := `{"name": "rabbitmq", "description": "Another broker", "user": "test"}`
+	for _, path := range []string{"/api/v1/swdocs/apply?dryRun=true", "/api/v1/swdocs/apply", "/api/v1/swdocs/batch"} {
+		body := doc
+		if strings.HasSuffix(path, "batch") {
+			body = "[" + doc + "]"
+		}
+		if rec := serveTestRequest(a, "POST", path, body); rec.Code != http.StatusConflict {
+			t.Errorf("%s: got status %d, want %d", path, rec.Code, http.StatusConflict)
+		}
+	}
+
+	if rec := serveTestRequest(a, "POST", "/api/v1/trash/rabbitmq/restore?user=test", ""); rec.Code != http.StatusOK {
+		t.Fatalf("got status %d restoring, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	restored, err := getSwDocByName(a.DB, "rabbitmq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Description != "A broker" {
+		t.Errorf("got description %q restored, want the trashed one", restored.Description)
+	}
+}
+
+func TestPurgingRemovesRedirects(t *testing.T) {
+	a := newTestApp(t)
+	applyTestSwDoc(t, a, SwDoc{Name: "rabbitmq", Description: "A broker"})
+	if rec := serveTestRequest(a, "POST", "/api/v1/swdocs/rabbitmq/rename", `{"name": "broker", "user": "test"}`); rec.Code != http.StatusOK {
+		t.Fatalf("got status %d renaming, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if canonical, _, _ := resolveSwDocName(a.DB, "rabbitmq"); canonical != "broker" {
+		t.Fatalf("got rabbitmq redirecting to %q, want broker", canonical)
+	}
+
+	serveTestRequest(a, "DELETE", "/api/v1/swdocs/broker?user=test", "")
+	if rec := serveTestRequest(a, "DELETE", "/api/v1/trash/broker", ""); rec.Code != http.StatusOK {
+		t.Fatalf("got status %d purging, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if canonical, _, _ := resolveSwDocName(a.DB, "rabbitmq"); canonical != "" {
+		t.Errorf("got rabbitmq redirecting to %q after purging it, want no redirect", canonical)
+	}
+	if rec := serveTestRequest(a, "GET", "/rabbitmq", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("got status %d for the old name, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,104 @@
+package swdocs
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	labelKeyRegexp   = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]{0,61}[A-Za-z0-9])?$`)
+	labelValueRegexp = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9._-]{0,61}[A-Za-z0-9])?)?$`)
+)
+
+type selectorOp string
+
+const (
+	opEquals    selectorOp = "="
+	opNotEquals selectorOp = "!="
+	opExists    selectorOp = "exists"
+	opNotExists selectorOp = "!exists"
+)
+
+type requirement struct {
+	key   string
+	op    selectorOp
+	value string
+}
+
+// Selector filters SwDocs by their labels, like the kubernetes label selectors.
+// An empty Selector matches everything.
+type Selector []requirement
+
+// ParseSelector parses a comma separated list of requirements, each of them
+// being one of "key=value", "key==value", "key!=value", "key" or "!key".
+func ParseSelector(s string) (Selector, error) {
+	var sel Selector
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		var r requirement
+		switch {
+		case strings.Contains(part, "!="):
+			kv := strings.SplitN(part, "!=", 2)
+			r = requirement{key: kv[0], op: opNotEquals, value: kv[1]}
+		case strings.Contains(part, "="):
+			kv := strings.SplitN(strings.Replace(part, "==", "=", 1), "=", 2)
+			r = requirement{key: kv[0], op: opEquals, value: kv[1]}
+		case strings.HasPrefix(part, "!"):
+			r = requirement{key: part[1:], op: opNotExists}
+		default:
+			r = requirement{key: part, op: opExists}
+		}
+
+		r.key = strings.TrimSpace(r.key)
+		r.value = strings.TrimSpace(r.value)
+		if !labelKeyRegexp.MatchString(r.key) || !labelValueRegexp.MatchString(r.value) {
+			return nil, fmt.Errorf("invalid selector requirement %q", part)
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+// Matches tells whether labels satisfy every requirement of the selector.
+func (sel Selector) Matches(labels map[string]string) bool {
+	for _, r := range sel {
+		v, ok := labels[r.key]
+		switch r.op {
+		case opEquals:
+			if !ok || v != r.value {
+				return false
+			}
+		case opNotEquals:
+			if ok && v == r.value {
+				return false
+			}
+		case opExists:
+			if !ok {
+				return false
+			}
+		case opNotExists:
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func filterSwDocs(docs []SwDoc, sel Selector) []SwDoc {
+	if len(sel) == 0 {
+		return docs
+	}
+	var filtered []SwDoc
+	for _, doc := range docs {
+		if sel.Matches(doc.Labels) {
+			filtered = append(filtered, doc)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,260 @@
+package swdocs
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LinkStatus is the outcome of the last check of a link.
+type LinkStatus struct {
+	URL         string     `json:"url"`
+	StatusCode  int        `json:"statusCode,omitempty"`
+	LatencyMs   int64      `json:"latencyMs"`
+	Error       string     `json:"error,omitempty"`
+	LastChecked *timeStamp `json:"lastChecked,omitempty"`
+}
+
+// Broken tells whether the link couldn't be reached or answered with an error.
+func (s LinkStatus) Broken() bool {
+	return s.Error != "" || s.StatusCode >= 400
+}
+
+// CheckLink requests the URL with HEAD, falling back to GET for servers which
+// don't support HEAD, and reports how it went. Timeouts are the client's.
+func CheckLink(client *http.Client, linkURL string) LinkStatus {
+	status := LinkStatus{URL: linkURL}
+	start := time.Now()
+
+	resp, err := doCheckRequest(client, "HEAD", linkURL)
+	if err != nil || resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented {
+		resp, err = doCheckRequest(client, "GET", linkURL)
+	}
+
+	status.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.StatusCode = resp.StatusCode
+	return status
+}
+
+func doCheckRequest(client *http.Client, method, linkURL string) (*http.Response, error) {
+	req, err := http.NewRequest(method, linkURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "swdocs-linkchecker")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	// Only the status matters, don't download whole pages.
+	io.CopyN(ioutil.Discard, resp.Body, 4096)
+	resp.Body.Close()
+	return resp, nil
+}
+
+// privateNetworks are the networks of the addresses not reachable from the
+// internet, on top of the loopback and link-local ones.
+var privateNetworks = []*net.IPNet{
+	mustParseCIDR("10.0.0.0/8"),
+	mustParseCIDR("172.16.0.0/12"),
+	mustParseCIDR("192.168.0.0/16"),
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("fc00::/7"),
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// isPrivateIP tells whether ip is a loopback, link-local or private address.
+func isPrivateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return true
+	}
+	for _, n := range privateNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPrivateHost tells whether host is, or resolves to, a private address.
+// Hosts which don't resolve aren't private, checking them reports the error.
+func isPrivateHost(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		var err error
+		if ips, err = net.LookupIP(host); err != nil {
+			return false
+		}
+	}
+	for _, ip := range ips {
+		if isPrivateIP(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewLinkCheckClient returns the client checking links. Unless allowPrivate
+// is set it refuses to connect to private addresses, so storing a link can't
+// make the server reach the internal network, not even through a redirect.
+func NewLinkCheckClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	return newGuardedClient(timeout, allowPrivate)
+}
+
+// newGuardedClient returns a client refusing to connect to private addresses
+// unless allowPrivate is set, for the requests to URLs given by users.
+func newGuardedClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+				return fmt.Errorf("%s is a private address, swdocs doesn't connect to it", host)
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// LinkChecker periodically checks every link of every SwDoc and stores their status.
+type LinkChecker struct {
+	DB *sql.DB
+	// Mutex is the one of the App, sqlite only allows one writer at a time.
+	Mutex       *sync.Mutex
+	Client      *http.Client
+	Interval    time.Duration
+	Concurrency int
+	// HostDelay is the minimum time between two requests to the same host.
+	HostDelay time.Duration
+	// AllowPrivate checks the links to private addresses too, they are
+	// skipped otherwise.
+	AllowPrivate bool
+
+	hostsMutex sync.Mutex
+	nextByHost map[string]time.Time
+}
+
+// Run checks every link every Interval, forever.
+func (c *LinkChecker) Run() {
+	for {
+		if err := c.CheckAll(); err != nil {
+			log.Error("Link checker failed: " + err.Error())
+		}
+		time.Sleep(c.Interval)
+	}
+}
+
+// CheckAll checks every http(s) link of every SwDoc once, but for the ones to
+// private addresses unless AllowPrivate is set.
+func (c *LinkChecker) CheckAll() error {
+	docs, err := getAllSwDocSections(c.DB)
+	if err != nil {
+		return err
+	}
+
+	// The links are checked one after the other for each host, waiting
+	// HostDelay between them without holding a slot of the concurrency, so
+	// many links to a host don't stall the checks of the others.
+	byHost := make(map[string][]string)
+	seen := make(map[string]bool)
+	for _, doc := range docs {
+		for _, sec := range doc.Sections {
+			for _, l := range sec.Links {
+				u, err := url.Parse(l.URL)
+				if err != nil || (u.Scheme != "http" && u.Scheme != "https") || seen[l.URL] {
+					continue
+				}
+				seen[l.URL] = true
+				if !c.AllowPrivate && isPrivateHost(u.Hostname()) {
+					log.Debug("Not checking " + l.URL + ", it is a private address")
+					continue
+				}
+				byHost[u.Host] = append(byHost[u.Host], l.URL)
+			}
+		}
+	}
+
+	links := 0
+	for _, hostURLs := range byHost {
+		links += len(hostURLs)
+	}
+	log.WithFields(log.Fields{
+		"links": links,
+		"hosts": len(byHost),
+	}).Info("Checking links")
+	concurrency := c.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for host, hostURLs := range byHost {
+		wg.Add(1)
+		go func(host string, hostURLs []string) {
+			defer wg.Done()
+			for _, linkURL := range hostURLs {
+				c.waitForHost(host)
+				semaphore <- struct{}{}
+				status := CheckLink(c.Client, linkURL)
+				<-semaphore
+
+				c.Mutex.Lock()
+				if err := saveLinkStatus(c.DB, status); err != nil {
+					log.Error("Failed to save the status of " + linkURL + ": " + err.Error())
+				}
+				c.Mutex.Unlock()
+			}
+		}(host, hostURLs)
+	}
+	wg.Wait()
+	return nil
+}
+
+// waitForHost blocks until a request to host is allowed by HostDelay.
+func (c *LinkChecker) waitForHost(host string) {
+	c.hostsMutex.Lock()
+	if c.nextByHost == nil {
+		c.nextByHost = make(map[string]time.Time)
+	}
+	now := time.Now()
+	next := c.nextByHost[host]
+	if next.Before(now) {
+		next = now
+	}
+	c.nextByHost[host] = next.Add(c.HostDelay)
+	c.hostsMutex.Unlock()
+
+	time.Sleep(time.Until(next))
+}
@@ -0,0 +1,251 @@
+package swdocs
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCheckLinkFallsBackToGet(t *testing.T) {
+	var methods []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		if r.Method == "HEAD" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	status := CheckLink(server.Client(), server.URL)
+	if status.StatusCode != http.StatusOK || status.Broken() {
+		t.Errorf("got status %d, error %q, want 200", status.StatusCode, status.Error)
+	}
+	if len(methods) != 2 || methods[0] != "HEAD" || methods[1] != "GET" {
+		t.Errorf("got requests %v, want HEAD then GET", methods)
+	}
+}
+
+func TestCheckLinkStatusCodes(t *testing.T) {
+	tests := []struct {
+		code   int
+		broken bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusNoContent, false},
+		{http.StatusUnauthorized, true},
+		{http.StatusNotFound, true},
+		{http.StatusGone, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusServiceUnavailable, true},
+	}
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.code)
+		}))
+
+		status := CheckLink(server.Client(), server.URL)
+		if status.StatusCode != tt.code {
+			t.Errorf("got status %d, want %d", status.StatusCode, tt.code)
+		}
+		if status.Broken() != tt.broken {
+			t.Errorf("status %d: got broken %v, want %v", tt.code, status.Broken(), tt.broken)
+		}
+		server.Close()
+	}
+}
+
+func TestCheckLinkTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := NewLinkCheckClient(50*time.Millisecond, true)
+	status := CheckLink(client, server.URL)
+	if status.Error == "" || !status.Broken() {
+		t.Errorf("got status %d and no error, want a timeout", status.StatusCode)
+	}
+}
+
+func TestCheckLinkUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	status := CheckLink(NewLinkCheckClient(time.Second, true), url)
+	if status.Error == "" || !status.Broken() {
+		t.Errorf("got status %d and no error, want the connection to fail", status.StatusCode)
+	}
+}
+
+func TestLinkCheckerHostDelay(t *testing.T) {
+	a := newTestApp(t)
+
+	var mutex sync.Mutex
+	var requests []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests = append(requests, time.Now())
+		mutex.Unlock()
+	}))
+	defer server.Close()
+
+	applyTestSwDoc(t, a, SwDoc{Name: "rabbitmq", Description: "A broker", Sections: sectionSlice{{
+		Header: "Links",
+		Links: linkSlice{
+			{URL: server.URL + "/a", Description: "a"},
+			{URL: server.URL + "/b", Description: "b"},
+			{URL: server.URL + "/c", Description: "c"},
+		},
+	}}})
+
+	delay := 100 * time.Millisecond
+	checker := &LinkChecker{
+		DB:           a.DB,
+		Mutex:        &a.Mutex,
+		Client:       NewLinkCheckClient(time.Second, true),
+		Concurrency:  3,
+		HostDelay:    delay,
+		AllowPrivate: true,
+	}
+	if err := checker.CheckAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(requests) != 3 {
+		t.Fatalf("got %d requests, want 3", len(requests))
+	}
+	for i := 1; i < len(requests); i++ {
+		// Allow for the timer to fire a bit early.
+		if gap := requests[i].Sub(requests[i-1]); gap < delay-10*time.Millisecond {
+			t.Errorf("requests %d and %d to the same host were %v apart, want at least %v", i-1, i, gap, delay)
+		}
+	}
+
+	statuses, err := getLinkStatuses(a.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 3 {
+		t.Errorf("got %d link statuses stored, want 3", len(statuses))
+	}
+}
+
+func TestLinkCheckerHostDelayDoesNotStallOtherHosts(t *testing.T) {
+	a := newTestApp(t)
+
+	var mutex sync.Mutex
+	requests := make(map[string]time.Time)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests[r.Host+r.URL.Path] = time.Now()
+		mutex.Unlock()
+	})
+	busy := httptest.NewServer(handler)
+	defer busy.Close()
+	other := httptest.NewServer(handler)
+	defer other.Close()
+
+	var links linkSlice
+	for _, path := range []string{"/a", "/b", "/c", "/d"} {
+		links = append(links, link{URL: busy.URL + path, Description: path})
+	}
+	links = append(links, link{URL: other.URL + "/e", Description: "e"})
+	applyTestSwDoc(t, a, SwDoc{Name: "rabbitmq", Description: "A broker", Sections: sectionSlice{{Header: "Links", Links: links}}})
+
+	delay := 200 * time.Millisecond
+	checker := &LinkChecker{
+		DB:           a.DB,
+		Mutex:        &a.Mutex,
+		Client:       NewLinkCheckClient(time.Second, true),
+		Concurrency:  1,
+		HostDelay:    delay,
+		AllowPrivate: true,
+	}
+	start := time.Now()
+	if err := checker.CheckAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(requests) != 5 {
+		t.Fatalf("got %d requests, want 5", len(requests))
+	}
+	if waited := requests[other.Listener.Addr().String()+"/e"].Sub(start); waited > delay {
+		t.Errorf("the link of the other host was checked after %v, want it not to wait for the busy host", waited)
+	}
+}
+
+func TestLinkCheckerSkipsPrivateAddresses(t *testing.T) {
+	a := newTestApp(t)
+
+	requested := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+	}))
+	defer server.Close()
+
+	applyTestSwDoc(t, a, SwDoc{Name: "internal", Description: "Internal tools", Sections: sectionSlice{{
+		Header: "Links",
+		Links: linkSlice{
+			{URL: server.URL, Description: "loopback"},
+			{URL: "http://localhost:1/admin", Description: "localhost"},
+			{URL: "http://169.254.169.254/latest/meta-data", Description: "metadata"},
+		},
+	}}})
+
+	checker := &LinkChecker{DB: a.DB, Mutex: &a.Mutex, Client: NewLinkCheckClient(time.Second, false)}
+	if err := checker.CheckAll(); err != nil {
+		t.Fatal(err)
+	}
+	if requested {
+		t.Error("the link to a loopback address was requested")
+	}
+	statuses, err := getLinkStatuses(a.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 0 {
+		t.Errorf("got %d link statuses stored, want none", len(statuses))
+	}
+}
+
+func TestLinkCheckClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the private address was requested")
+	}))
+	defer server.Close()
+
+	status := CheckLink(NewLinkCheckClient(time.Second, false), server.URL)
+	if status.Error == "" {
+		t.Errorf("got status %d, want the connection to be refused", status.StatusCode)
+	}
+}
+
+func TestIsPrivateIP(t *testing.T) {
+	tests := map[string]bool{
+		"127.0.0.1":       true,
+		"::1":             true,
+		"10.1.2.3":        true,
+		"172.16.0.1":      true,
+		"172.32.0.1":      false,
+		"192.168.1.1":     true,
+		"169.254.169.254": true,
+		"100.64.0.1":      true,
+		"fd00::1":         true,
+		"0.0.0.0":         true,
+		"8.8.8.8":         false,
+		"2001:4860::8888": false,
+	}
+	for addr, want := range tests {
+		if got := isPrivateIP(net.ParseIP(addr)); got != want {
+			t.Errorf("isPrivateIP(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package swdocs
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+)
+
+// markdown renders the descriptions as CommonMark. It isn't configured as
+// unsafe, so raw HTML is omitted and links to javascript: and the like are dropped.
+var markdown = goldmark.New()
+
+// renderMarkdown renders the Markdown of a description as HTML which is safe to embed in a page.
+func renderMarkdown(source string) template.HTML {
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(source), &buf); err != nil {
+		return template.HTML("<p>" + template.HTMLEscapeString(source) + "</p>")
+	}
+	return template.HTML(buf.String())
+}
+
+// MarkdownToText renders the Markdown of a description as plain text for
+// terminals, links are followed by their URL and list items by a bullet.
+func MarkdownToText(source string) string {
+	src := []byte(source)
+	var b strings.Builder
+
+	ast.Walk(markdown.Parser().Parse(text.NewReader(src)), func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		switch n := n.(type) {
+		case *ast.Text:
+			if entering {
+				b.Write(n.Segment.Value(src))
+				if n.SoftLineBreak() || n.HardLineBreak() {
+					b.WriteString("\n" + listIndent(n))
+				}
+			}
+		case *ast.String:
+			if entering {
+				b.Write(n.Value)
+			}
+		case *ast.AutoLink:
+			if entering {
+				b.Write(n.URL(src))
+			}
+			return ast.WalkSkipChildren, nil
+		case *ast.Link:
+			if !entering {
+				writeDestination(&b, n.Destination)
+			}
+		case *ast.Image:
+			if !entering {
+				writeDestination(&b, n.Destination)
+			}
+		case *ast.RawHTML, *ast.HTMLBlock:
+			return ast.WalkSkipChildren, nil
+		case *ast.ListItem:
+			if entering {
+				startBlock(&b, n)
+				b.WriteString(listMarker(n))
+			}
+		case *ast.FencedCodeBlock, *ast.CodeBlock:
+			if entering {
+				startBlock(&b, n)
+				lines := n.Lines()
+				for i := 0; i < lines.Len(); i++ {
+					line := lines.At(i)
+					if i > 0 {
+						b.WriteString("\n" + listIndent(n))
+					}
+					b.WriteString("    " + strings.TrimRight(string(line.Value(src)), "\n"))
+				}
+			}
+			return ast.WalkSkipChildren, nil
+		case *ast.ThematicBreak:
+			if entering {
+				startBlock(&b, n)
+				b.WriteString("----")
+			}
+		case *ast.Paragraph, *ast.TextBlock, *ast.Heading:
+			if entering {
+				startBlock(&b, n)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+
+	return strings.TrimSpace(b.String())
+}
+
+// writeDestination writes the URL of a link after its text, unless it is
+// dropped from the HTML too.
+func writeDestination(b *strings.Builder, destination []byte) {
+	if len(destination) > 0 && !html.IsDangerousURL(destination) {
+		b.WriteString(" (" + string(destination) + ")")
+	}
+}
+
+// startBlock separates a block from the text before it, the first block of a
+// list item goes right after its bullet.
+func startBlock(b *strings.Builder, n ast.Node) {
+	if b.Len() == 0 {
+		return
+	}
+	parent := n.Parent()
+	if _, ok := parent.(*ast.ListItem); ok && n.PreviousSibling() == nil {
+		return
+	}
+
+	_, inItem := parent.(*ast.ListItem)
+	_, isItem := n.(*ast.ListItem)
+	if isItem {
+		// The items of a list go one per line, nested lists right below their item.
+		_, inItem = parent.Parent().(*ast.ListItem)
+		inItem = inItem || n.PreviousSibling() != nil
+	}
+	if inItem {
+		b.WriteString("\n" + listIndent(n))
+		return
+	}
+	b.WriteString("\n\n")
+}
+
+// listIndent is the indentation of the text of the list items n is in.
+func listIndent(n ast.Node) string {
+	depth := 0
+	for p := n.Parent(); p != nil; p = p.Parent() {
+		if _, ok := p.(*ast.ListItem); ok {
+			depth++
+		}
+	}
+	return strings.Repeat("  ", depth)
+}
+
+func listMarker(item *ast.ListItem) string {
+	list, ok := item.Parent().(*ast.List)
+	if !ok || !list.IsOrdered() {
+		return "* "
+	}
+	i := list.Start
+	for s := item.PreviousSibling(); s != nil; s = s.PreviousSibling() {
+		i++
+	}
+	return fmt.Sprintf("%d. ", i)
+}
@@ -1,12 +1,86 @@
 package swdocs
 
 import (
+	"crypto/sha1"
 	"database/sql/driver"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
 	"strings"
 	"time"
 )
 
+// Actions reported back when applying a SwDoc.
+const (
+	ActionCreated   = "created"
+	ActionUpdated   = "updated"
+	ActionUnchanged = "unchanged"
+)
+
+// Types of the events recorded when a SwDoc changes.
+const (
+	EventCreated  = "created"
+	EventUpdated  = "updated"
+	EventDeleted  = "deleted"
+	EventRenamed  = "renamed"
+	EventRestored = "restored"
+)
+
+// eventTypes are the types of events which can be subscribed to.
+var eventTypes = []string{EventCreated, EventUpdated, EventDeleted, EventRenamed, EventRestored}
+
+// Types of the relations between SwDocs.
+const (
+	RelationDependsOn = "depends-on"
+	RelationUsedBy    = "used-by"
+	RelationSeeAlso   = "see-also"
+)
+
+var relationTypes = []string{RelationDependsOn, RelationUsedBy, RelationSeeAlso}
+
+// ownerLabel is the label naming who looks after a SwDoc, the user who last
+// updated it is assumed to when it isn't set.
+const ownerLabel = "owner"
+
+// timeStampLayouts are the layouts timestamps are parsed with, the ones
+// without a timezone are in UTC. Timestamps are stored as RFC 3339, they used
+// to be stored like CURRENT_TIMESTAMP formats them, "2006-01-02 15:04:05".
+var timeStampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+var (
+	slugRegexp        = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	nonAlphanumRegexp = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// reservedNames can't be used as SwDoc names as they clash with the web app routes.
+var reservedNames = map[string]bool{
+	"all":       true,
+	"api":       true,
+	"teams":     true,
+	"users":     true,
+	"feed.atom": true,
+	"feed.rss":  true,
+	"go":        true,
+	"graph":     true,
+	"new":       true,
+	"search":    true,
+}
+
+// reservedSlugs can't be used as link slugs as they clash with the pages of a SwDoc.
+var reservedSlugs = map[string]bool{
+	"delete": true,
+	"edit":   true,
+	"graph":  true,
+}
+
 // SwDoc is the struct that represents or docs
 type SwDoc struct {
 	ID          int64        `json:"id,omitempty"`
@@ -15,8 +89,116 @@ type SwDoc struct {
 	Created     *timeStamp   `json:"created,omitempty"`
 	Updated     *timeStamp   `json:"updated,omitempty"`
 	Description string       `json:"description"`
-	Related     string       `json:"related,omitempty"`
+	Related     []Relation   `json:"related,omitempty"`
+	Aliases     aliasList    `json:"aliases,omitempty"`
+	Labels      labelMap     `json:"labels,omitempty"`
+	Revision    int64        `json:"revision,omitempty"`
 	Sections    sectionSlice `json:"sections,omitempty"`
+	// Stale is set when the SwDoc wasn't updated for longer than the configured threshold.
+	Stale bool `json:"stale,omitempty"`
+	// Deleted and DeletedBy are only set on the SwDocs in the trash.
+	Deleted   *timeStamp `json:"deleted,omitempty"`
+	DeletedBy string     `json:"deletedBy,omitempty"`
+}
+
+// ApplyResult is the outcome of applying a single SwDoc.
+type ApplyResult struct {
+	Name    string        `json:"name"`
+	Action  string        `json:"action,omitempty"`
+	Error   string        `json:"error,omitempty"`
+	Changes []FieldChange `json:"changes,omitempty"`
+}
+
+// RenameRequest is the payload to rename a SwDoc.
+type RenameRequest struct {
+	Name string `json:"name"`
+	User string `json:"user,omitempty"`
+}
+
+// Event is a change to a SwDoc. SwDoc is how it looked right after the
+// change, or right before it when it was deleted.
+type Event struct {
+	ID       int64      `json:"id"`
+	Type     string     `json:"type"`
+	Name     string     `json:"name"`
+	OldName  string     `json:"oldName,omitempty"`
+	Revision int64      `json:"revision,omitempty"`
+	User     string     `json:"user,omitempty"`
+	Created  *timeStamp `json:"created,omitempty"`
+	SwDoc    *SwDoc     `json:"swdoc,omitempty"`
+}
+
+// Relation links a SwDoc to another one called Name.
+type Relation struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// RelatedSwDoc is a SwDoc related to another one, along with its description.
+type RelatedSwDoc struct {
+	Relation
+	Description string `json:"description,omitempty"`
+}
+
+// RelatedSwDocs are the SwDocs a SwDoc is related to and the ones related to
+// it, the latter with the type of relation they gave.
+type RelatedSwDocs struct {
+	Name         string         `json:"name"`
+	Related      []RelatedSwDoc `json:"related"`
+	ReferencedBy []RelatedSwDoc `json:"referencedBy"`
+}
+
+// StaleGroup is the list of stale SwDocs of a single owner.
+type StaleGroup struct {
+	Owner  string  `json:"owner"`
+	SwDocs []SwDoc `json:"swdocs"`
+}
+
+// UserActivity is what a user did, the SwDocs they updated last and their
+// latest changes, newest first.
+type UserActivity struct {
+	User   string  `json:"user"`
+	SwDocs []SwDoc `json:"swdocs"`
+	Events []Event `json:"events"`
+}
+
+// TeamSwDocs are the SwDocs whose owner label is Team, along with how many
+// of them are stale and how many of their links are broken.
+type TeamSwDocs struct {
+	Team        string      `json:"team"`
+	SwDocs      []TeamSwDoc `json:"swdocs"`
+	Stale       int         `json:"stale"`
+	BrokenLinks int         `json:"brokenLinks"`
+}
+
+// TeamSwDoc is a SwDoc of a team along with how many of its links are broken.
+type TeamSwDoc struct {
+	SwDoc
+	BrokenLinks int `json:"brokenLinks"`
+}
+
+// ValidationError describes a problem with a single field of a SwDoc.
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors is the list of problems found when validating a SwDoc.
+type ValidationErrors []ValidationError
+
+type swDocStats struct {
+	Name   string      `json:"name"`
+	Clicks int64       `json:"clicks"`
+	Links  []linkStats `json:"links,omitempty"`
+}
+
+type linkStats struct {
+	ID          string     `json:"id"`
+	Section     string     `json:"section"`
+	Description string     `json:"description"`
+	URL         string     `json:"url"`
+	Clicks      int64      `json:"clicks"`
+	LastClicked *timeStamp `json:"lastClicked,omitempty"`
 }
 
 type swDocsSlice struct {
@@ -25,6 +207,10 @@ type swDocsSlice struct {
 
 type timeStamp time.Time
 
+type aliasList []string
+
+type labelMap map[string]string
+
 type sectionSlice []section
 
 type section struct {
@@ -38,6 +224,103 @@ type linkSlice []link
 type link struct {
 	URL         string `json:"url"`
 	Description string `json:"description"`
+	Slug        string `json:"slug,omitempty"`
+}
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, v := range e {
+		msgs[i] = v.Field + ": " + v.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *ValidationErrors) add(field, format string, a ...interface{}) {
+	*e = append(*e, ValidationError{Field: field, Message: fmt.Sprintf(format, a...)})
+}
+
+// validateName checks names and aliases, which are used in the URLs.
+func validateName(errs *ValidationErrors, field, name string) {
+	trimmed := strings.TrimSpace(name)
+	if trimmed == "" {
+		errs.add(field, "is required")
+	} else if trimmed != name || strings.ContainsAny(name, "/?#%,") {
+		errs.add(field, "can't contain spaces around it nor any of '/?#%%,'")
+	} else if reservedNames[strings.ToLower(name)] {
+		errs.add(field, "%q is reserved", name)
+	}
+}
+
+// Validate checks the SwDoc is well formed before it is stored.
+func (s *SwDoc) Validate() error {
+	var errs ValidationErrors
+
+	validateName(&errs, "name", s.Name)
+
+	seen := map[string]bool{s.Name: true}
+	for i, alias := range s.Aliases {
+		field := fmt.Sprintf("aliases[%d]", i)
+		validateName(&errs, field, alias)
+		if seen[alias] {
+			errs.add(field, "%q is repeated or is the name of the SwDoc", alias)
+		}
+		seen[alias] = true
+	}
+
+	for k, v := range s.Labels {
+		if !labelKeyRegexp.MatchString(k) {
+			errs.add("labels", "invalid key %q, use letters, digits and '-_./'", k)
+		} else if !labelValueRegexp.MatchString(v) {
+			errs.add("labels."+k, "invalid value %q, use letters, digits and '-_.'", v)
+		}
+	}
+
+	relations := make(map[Relation]bool)
+	for i, rel := range s.Related {
+		field := fmt.Sprintf("related[%d]", i)
+		if rel.Name == "" {
+			errs.add(field+".name", "is required")
+		} else if rel.Name == s.Name {
+			errs.add(field+".name", "a SwDoc can't be related to itself")
+		}
+		if !isRelationType(rel.Type) {
+			errs.add(field+".type", "invalid type %q, use %s", rel.Type, strings.Join(relationTypes, ", "))
+		}
+		if relations[rel] {
+			errs.add(field, "%s %s is repeated", rel.Type, rel.Name)
+		}
+		relations[rel] = true
+	}
+
+	slugs := make(map[string]bool)
+	for i, sec := range s.Sections {
+		field := fmt.Sprintf("sections[%d]", i)
+		if strings.TrimSpace(sec.Header) == "" {
+			errs.add(field+".header", "is required")
+		}
+		for j, l := range sec.Links {
+			field := fmt.Sprintf("%s.links[%d]", field, j)
+			if u, err := url.Parse(l.URL); l.URL == "" || err != nil || u.Scheme == "" {
+				errs.add(field+".url", "must be an absolute URL")
+			}
+			if l.Slug == "" {
+				continue
+			}
+			if !slugRegexp.MatchString(l.Slug) {
+				errs.add(field+".slug", "use lowercase letters, digits and dashes")
+			} else if reservedSlugs[l.Slug] {
+				errs.add(field+".slug", "%q is reserved", l.Slug)
+			} else if slugs[l.Slug] {
+				errs.add(field+".slug", "%q is used by another link", l.Slug)
+			}
+			slugs[l.Slug] = true
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
 }
 
 // Value - Implementation of valuer for database/sql
@@ -55,30 +338,173 @@ func (s *sectionSlice) Scan(v interface{}) error {
 	return json.Unmarshal(data, s)
 }
 
+// Value - Implementation of valuer for database/sql
+func (l labelMap) Value() (driver.Value, error) {
+	return json.Marshal(l)
+}
+
+func (l *labelMap) Scan(v interface{}) error {
+	var data []byte
+	if b, ok := v.([]byte); ok {
+		data = b
+	} else if s, ok := v.(string); ok {
+		data = []byte(s)
+	} else if v == nil {
+		// SwDocs created before labels existed.
+		return nil
+	}
+	return json.Unmarshal(data, l)
+}
+
+func (a *aliasList) Scan(v interface{}) error {
+	var data string
+	if b, ok := v.([]byte); ok {
+		data = string(b)
+	} else if s, ok := v.(string); ok {
+		data = s
+	}
+	if data == "" {
+		*a = nil
+		return nil
+	}
+	*a = strings.Split(data, ",")
+	return nil
+}
+
+func isRelationType(t string) bool {
+	for _, relationType := range relationTypes {
+		if t == relationType {
+			return true
+		}
+	}
+	return false
+}
+
+// inverseRelation is the type of a relation seen from the other SwDoc,
+// when A depends on B then B is used by A.
+func inverseRelation(t string) string {
+	switch t {
+	case RelationDependsOn:
+		return RelationUsedBy
+	case RelationUsedBy:
+		return RelationDependsOn
+	}
+	return t
+}
+
+// Owner is the value of the owner label or, without it, the user who last updated the SwDoc.
+func (s *SwDoc) Owner() string {
+	if owner := s.Labels[ownerLabel]; owner != "" {
+		return owner
+	}
+	return s.User
+}
+
+// isStale tells whether the SwDoc wasn't updated for longer than after, 0 never expires.
+func (s *SwDoc) isStale(after time.Duration) bool {
+	return after > 0 && s.Updated != nil && time.Since(time.Time(*s.Updated)) > after
+}
+
+// Anchor is the id of the section in the SwDoc page, derived from its header.
+func (s section) Anchor() string {
+	return strings.Trim(nonAlphanumRegexp.ReplaceAllString(strings.ToLower(s.Header), "-"), "-")
+}
+
+// ID identifies the link in the click tracking URLs, it is derived from the
+// URL so it doesn't change when links are reordered.
+func (l link) ID() string {
+	sum := sha1.Sum([]byte(l.URL))
+	return hex.EncodeToString(sum[:6])
+}
+
+// findLink returns the link with the given ID.
+func (s *SwDoc) findLink(id string) (link, bool) {
+	for _, sec := range s.Sections {
+		for _, l := range sec.Links {
+			if l.ID() == id {
+				return l, true
+			}
+		}
+	}
+	return link{}, false
+}
+
+// findSlug returns the link with the given slug or, if there is none, the
+// anchor of the section matching the slug.
+func (s *SwDoc) findSlug(slug string) (l *link, anchor string) {
+	for _, sec := range s.Sections {
+		for i := range sec.Links {
+			if sec.Links[i].Slug == slug {
+				return &sec.Links[i], ""
+			}
+		}
+	}
+	for _, sec := range s.Sections {
+		if sec.Anchor() == slug {
+			return nil, sec.Anchor()
+		}
+	}
+	return nil, ""
+}
+
 func (t *timeStamp) MarshalJSON() ([]byte, error) {
 	return json.Marshal(time.Time(*t))
 }
 
 func (t *timeStamp) UnmarshalJSON(data []byte) error {
-	cleanStr := strings.Trim(string(data), "\"")
-	timeStruct, err := time.Parse("2006-01-02T15:04:05Z", cleanStr)
-	if err != nil {
+	if string(data) == "null" {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
 		return err
 	}
-	*t = timeStamp(timeStruct)
-	return nil
+	return t.parse(s)
+}
+
+// Value stores the timestamp as RFC 3339 in UTC, so timestamps sort and
+// compare as strings.
+func (t timeStamp) Value() (driver.Value, error) {
+	return time.Time(t).UTC().Format(time.RFC3339), nil
 }
 
 func (t *timeStamp) Scan(v interface{}) error {
-	// Should be more strictly to check this type.
-	vt, err := time.Parse("2006-01-02 15:04:05", v.(string))
-	if err != nil {
-		return err
+	switch v := v.(type) {
+	case time.Time:
+		*t = timeStamp(v)
+		return nil
+	case []byte:
+		return t.parse(string(v))
+	case string:
+		return t.parse(v)
+	case nil:
+		*t = timeStamp{}
+		return nil
 	}
-	*t = timeStamp(vt)
-	return nil
+	return fmt.Errorf("can't scan %T into a timestamp", v)
+}
+
+// parse reads a timestamp in any of the timeStampLayouts.
+func (t *timeStamp) parse(s string) error {
+	for _, layout := range timeStampLayouts {
+		if parsed, err := time.Parse(layout, s); err == nil {
+			*t = timeStamp(parsed)
+			return nil
+		}
+	}
+	return fmt.Errorf("%q isn't a valid timestamp, use RFC 3339", s)
 }
 
 func (t *timeStamp) ToString() string {
 	return time.Time(*t).Format("2006-01-02")
 }
+
+// Format shows the date and time in loc, along with the timezone.
+func (t *timeStamp) Format(loc *time.Location) string {
+	return time.Time(*t).In(loc).Format(dateTimeLayout)
+}
+
+// Ago tells how long ago the time was, like "3 days ago".
+func (t *timeStamp) Ago() string {
+	return relativeTime(time.Time(*t), time.Now())
+}
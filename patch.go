@@ -0,0 +1,78 @@
+package swdocs
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+// Content types accepted when patching a SwDoc.
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JSONPatchContentType  = "application/json-patch+json"
+)
+
+var errUnsupportedPatch = errors.New("unsupported patch, use " + MergePatchContentType + " or " + JSONPatchContentType)
+
+// patchSwDoc applies a JSON Merge Patch (RFC 7386) or a JSON Patch (RFC 6902),
+// depending on contentType, to the stored SwDoc. Server managed fields are kept.
+func patchSwDoc(stored SwDoc, contentType string, patch []byte) (SwDoc, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return stored, errUnsupportedPatch
+	}
+
+	// Empty lists and maps are omitted from the JSON, add them back so
+	// patches can add elements to them, e.g. "/sections/-".
+	var doc map[string]interface{}
+	b, err := json.Marshal(stored)
+	if err != nil {
+		return stored, err
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return stored, err
+	}
+	if _, ok := doc["sections"]; !ok {
+		doc["sections"] = []interface{}{}
+	}
+	if _, ok := doc["labels"]; !ok {
+		doc["labels"] = map[string]interface{}{}
+	}
+	if _, ok := doc["aliases"]; !ok {
+		doc["aliases"] = []interface{}{}
+	}
+	if _, ok := doc["related"]; !ok {
+		doc["related"] = []interface{}{}
+	}
+	if b, err = json.Marshal(doc); err != nil {
+		return stored, err
+	}
+
+	switch mediaType {
+	case MergePatchContentType:
+		b, err = jsonpatch.MergePatch(b, patch)
+	case JSONPatchContentType:
+		var p jsonpatch.Patch
+		p, err = jsonpatch.DecodePatch(patch)
+		if err == nil {
+			b, err = p.Apply(b)
+		}
+	default:
+		return stored, errUnsupportedPatch
+	}
+	if err != nil {
+		return stored, err
+	}
+
+	var patched SwDoc
+	if err := json.Unmarshal(b, &patched); err != nil {
+		return stored, err
+	}
+	patched.ID = stored.ID
+	patched.Revision = stored.Revision
+	patched.Created = stored.Created
+	patched.Updated = stored.Updated
+	return patched, nil
+}
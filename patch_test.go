@@ -0,0 +1,34 @@
+package swdocs
+
+import "testing"
+
+func TestJSONPatchAddsToEmptyLists(t *testing.T) {
+	stored := SwDoc{Name: "consumer", Description: "Reads the queue", User: "test", Revision: 3}
+
+	tests := []struct {
+		patch string
+		check func(SwDoc) bool
+	}{
+		{`[{"op": "add", "path": "/related/-", "value": {"name": "rabbitmq", "type": "depends-on"}}]`,
+			func(s SwDoc) bool { return len(s.Related) == 1 && s.Related[0].Name == "rabbitmq" }},
+		{`[{"op": "add", "path": "/aliases/-", "value": "reader"}]`,
+			func(s SwDoc) bool { return len(s.Aliases) == 1 && s.Aliases[0] == "reader" }},
+		{`[{"op": "add", "path": "/sections/-", "value": {"header": "Links", "links": []}}]`,
+			func(s SwDoc) bool { return len(s.Sections) == 1 && s.Sections[0].Header == "Links" }},
+		{`[{"op": "add", "path": "/labels/team", "value": "payments"}]`,
+			func(s SwDoc) bool { return s.Labels["team"] == "payments" }},
+	}
+	for _, tt := range tests {
+		patched, err := patchSwDoc(stored, JSONPatchContentType, []byte(tt.patch))
+		if err != nil {
+			t.Errorf("%s: %v", tt.patch, err)
+			continue
+		}
+		if !tt.check(patched) {
+			t.Errorf("%s: got %+v", tt.patch, patched)
+		}
+		if patched.Revision != stored.Revision {
+			t.Errorf("%s: got revision %d, want the stored one %d", tt.patch, patched.Revision, stored.Revision)
+		}
+	}
+}
@@ -1,8 +1,19 @@
 package swdocs
 
-import "database/sql"
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
 
 const (
+	// nowSQL is the current time as RFC 3339 in UTC, how timestamps are
+	// stored. The defaults of the tables are still CURRENT_TIMESTAMP, so
+	// inserts set their timestamps.
+	nowSQL = "strftime('%Y-%m-%dT%H:%M:%SZ', 'now')"
+
 	dbSchema = `
     CREATE TABLE IF NOT EXISTS swdocs (
 		id INTEGER PRIMARY KEY,
@@ -13,26 +24,201 @@ const (
 		description TEXT,
 		sections TEXT)
 	`
-	createOrUpdateSwDocSQL = `INSERT INTO swdocs (name, description, user, sections) VALUES (?, ?, ?, ?)
+	createOrUpdateSwDocSQL = `INSERT INTO swdocs (name, description, user, sections, labels, created, updated) VALUES (?, ?, ?, ?, ?, ` + nowSQL + `, ` + nowSQL + `)
 								ON CONFLICT (name) DO UPDATE SET
 									sections=excluded.sections,
+									labels=excluded.labels,
 									description=excluded.description,
 									user=excluded.user,
-									updated=CURRENT_TIMESTAMP`
-	getSwDocSQL              = "SELECT name, description, sections, user, updated FROM swdocs WHERE name=?"
-	getRecentCreatedSwDocSQL = "SELECT name, description, user, created, updated FROM swdocs ORDER BY ID DESC LIMIT 15"
-	getRecentUpdatedSwDocSQL = "SELECT name, description, user, created, updated FROM swdocs ORDER BY updated DESC LIMIT 15"
-	searchSwDocSQL           = "SELECT name, user, updated FROM swdocs WHERE name like ?"
-	deleteSwDocSQL           = "DELETE FROM swdocs WHERE name=?"
+									revision=swdocs.revision+1,
+									updated=` + nowSQL
+	swDocExistsSQL           = "SELECT COUNT(*) FROM swdocs WHERE name=? AND deleted_at IS NULL"
+	getSwDocRevisionSQL      = "SELECT revision FROM swdocs WHERE name=?"
+	getSwDocSQL              = "SELECT name, description, sections, labels, " + aliasesColumnSQL + ", user, revision, created, updated FROM swdocs WHERE name=? AND deleted_at IS NULL"
+	getRecentCreatedSwDocSQL = "SELECT name, description, user, created, updated FROM swdocs WHERE deleted_at IS NULL ORDER BY ID DESC LIMIT 15"
+	getRecentUpdatedSwDocSQL = "SELECT name, description, user, created, updated FROM swdocs WHERE deleted_at IS NULL ORDER BY updated DESC LIMIT 15"
+	searchSwDocSQL           = "SELECT name, labels, " + aliasesColumnSQL + `, user, updated FROM swdocs
+									WHERE deleted_at IS NULL AND (name LIKE ? OR name IN (SELECT name FROM swdoc_aliases WHERE alias LIKE ?))
+									ORDER BY ` + popularityColumnSQL + " DESC, name"
+	renameSwDocSQL = "UPDATE swdocs SET name=?, user=?, revision=revision+1, updated=" + nowSQL + " WHERE name=?"
+
+	// Deleted SwDocs are kept in the trash, with their aliases and clicks, until purged.
+	trashSwDocSQL       = "UPDATE swdocs SET deleted_at=" + nowSQL + ", deleted_by=? WHERE name=? AND deleted_at IS NULL"
+	restoreSwDocSQL     = "UPDATE swdocs SET deleted_at=NULL, deleted_by='', user=?, revision=revision+1, updated=" + nowSQL + " WHERE name=?"
+	getTrashSQL         = "SELECT name, description, " + aliasesColumnSQL + ", user, revision, updated, deleted_at, deleted_by FROM swdocs WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC, name"
+	isTrashedSQL        = "SELECT COUNT(*) FROM swdocs WHERE name=? AND deleted_at IS NOT NULL"
+	getTrashedBeforeSQL = "SELECT name FROM swdocs WHERE deleted_at <= ? ORDER BY name"
+	purgeSwDocSQL       = "DELETE FROM swdocs WHERE name=? AND deleted_at IS NOT NULL"
+
+	createRedirectSQL = `INSERT INTO swdoc_redirects (old_name, new_name) VALUES (?, ?)
+							ON CONFLICT (old_name) DO UPDATE SET new_name=excluded.new_name`
+	updateRedirectsSQL   = "UPDATE swdoc_redirects SET new_name=? WHERE new_name=?"
+	deleteRedirectSQL    = "DELETE FROM swdoc_redirects WHERE old_name=?"
+	deleteRedirectsToSQL = "DELETE FROM swdoc_redirects WHERE new_name=?"
+	getRedirectSQL       = "SELECT new_name FROM swdoc_redirects WHERE old_name=?"
+
+	// aliasesColumnSQL selects the aliases of a SwDoc as a comma separated list.
+	aliasesColumnSQL = "(SELECT GROUP_CONCAT(alias, ',') FROM swdoc_aliases WHERE swdoc_aliases.name = swdocs.name)"
+	createAliasSQL   = "INSERT INTO swdoc_aliases (alias, name) VALUES (?, ?)"
+	deleteAliasesSQL = "DELETE FROM swdoc_aliases WHERE name=?"
+	deleteAliasSQL   = "DELETE FROM swdoc_aliases WHERE alias=?"
+	renameAliasesSQL = "UPDATE swdoc_aliases SET name=? WHERE name=?"
+	// The aliases of SwDocs in the trash are free to be taken.
+	getAliasOwnerSQL = `SELECT a.name FROM swdoc_aliases a JOIN swdocs s ON s.name = a.name
+							WHERE a.alias=? AND s.deleted_at IS NULL`
+
+	createRelationSQL  = "INSERT INTO swdoc_relations (name, related, type) VALUES (?, ?, ?)"
+	deleteRelationsSQL = "DELETE FROM swdoc_relations WHERE name=?"
+	getRelationsSQL    = "SELECT related, type FROM swdoc_relations WHERE name=? ORDER BY rowid"
+	// The relations with SwDocs in the trash are kept for when they are restored, but not shown.
+	getRelatedSQL = `SELECT r.related, r.type, s.description FROM swdoc_relations r JOIN swdocs s ON s.name = r.related
+							WHERE r.name=? AND s.deleted_at IS NULL ORDER BY r.rowid`
+	getReferencedBySQL = `SELECT r.name, r.type, s.description FROM swdoc_relations r JOIN swdocs s ON s.name = r.name
+							WHERE r.related=? AND s.deleted_at IS NULL ORDER BY r.name, r.type`
+	renameRelationsSQL = "UPDATE swdoc_relations SET name=? WHERE name=?"
+	renameRelatedSQL   = "UPDATE swdoc_relations SET related=? WHERE related=?"
+	purgeRelationsSQL  = "DELETE FROM swdoc_relations WHERE name=? OR related=?"
+	getGraphNodesSQL   = "SELECT name, description FROM swdocs WHERE deleted_at IS NULL ORDER BY name"
+	getAllRelationsSQL = "SELECT name, related, type FROM swdoc_relations ORDER BY rowid"
+
+	recordLinkClickSQL = `INSERT INTO link_clicks (name, link_id, url, clicks, last_clicked) VALUES (?, ?, ?, 1, ` + nowSQL + `)
+							ON CONFLICT (name, link_id) DO UPDATE SET
+								clicks=clicks+1,
+								url=excluded.url,
+								last_clicked=` + nowSQL
+	getLinkClicksSQL  = "SELECT link_id, clicks, last_clicked FROM link_clicks WHERE name=?"
+	getMostVisitedSQL = `SELECT c.name, SUM(c.clicks) AS total FROM link_clicks c JOIN swdocs s ON s.name = c.name
+							WHERE s.deleted_at IS NULL GROUP BY c.name ORDER BY total DESC, c.name LIMIT ?`
+	renameLinkClicksSQL = "UPDATE link_clicks SET name=? WHERE name=?"
+	deleteLinkClicksSQL = "DELETE FROM link_clicks WHERE name=?"
+	// popularityColumnSQL is the number of clicks on the links of a SwDoc.
+	popularityColumnSQL = "(SELECT COALESCE(SUM(clicks), 0) FROM link_clicks WHERE link_clicks.name = swdocs.name)"
+
+	getStaleSwDocsSQL      = "SELECT name, description, labels, user, updated FROM swdocs WHERE updated < ? AND deleted_at IS NULL ORDER BY updated, name"
+	getAllSwDocSectionsSQL = "SELECT name, sections FROM swdocs WHERE deleted_at IS NULL ORDER BY name"
+	getUserSwDocsSQL       = "SELECT name, description, labels, " + aliasesColumnSQL + ", user, updated FROM swdocs WHERE user=? AND deleted_at IS NULL ORDER BY updated DESC, name"
+	getAllSwDocsSQL        = "SELECT name, description, labels, " + aliasesColumnSQL + ", user, updated FROM swdocs WHERE deleted_at IS NULL ORDER BY name COLLATE NOCASE, name"
+	saveLinkStatusSQL      = `INSERT INTO link_status (url, status_code, latency_ms, error, last_checked) VALUES (?, ?, ?, ?, ` + nowSQL + `)
+								ON CONFLICT (url) DO UPDATE SET
+									status_code=excluded.status_code,
+									latency_ms=excluded.latency_ms,
+									error=excluded.error,
+									last_checked=excluded.last_checked`
+	getLinkStatusesSQL = "SELECT url, status_code, latency_ms, error, last_checked FROM link_status"
+
+	createEventSQL     = "INSERT INTO events (type, name, old_name, revision, user, swdoc, created) VALUES (?, ?, ?, ?, ?, ?, " + nowSQL + ")"
+	getEventsAfterSQL  = "SELECT id, type, name, old_name, revision, user, swdoc, created FROM events WHERE id > ? ORDER BY id LIMIT ?"
+	getEventsBeforeSQL = "SELECT id, type, name, old_name, revision, user, swdoc, created FROM events WHERE id < ? ORDER BY id DESC LIMIT ?"
+	getSwDocEventsSQL  = `SELECT id, type, name, old_name, revision, user, swdoc, created FROM events
+							WHERE name=? OR old_name=? ORDER BY id DESC LIMIT ?`
+	// The snapshots of the SwDocs are left out of the activity of a user.
+	getUserEventsSQL  = "SELECT id, type, name, old_name, revision, user, NULL, created FROM events WHERE user=? ORDER BY id DESC LIMIT ?"
+	getLastEventIDSQL = "SELECT COALESCE(MAX(id), 0) FROM events"
+	// The SwDocs not changed since before events were recorded.
+	getSwDocsWithoutEventsSQL = `SELECT name, description, labels, user, revision, updated FROM swdocs
+									WHERE deleted_at IS NULL AND name NOT IN (SELECT name FROM events)
+									ORDER BY updated DESC, name`
+	createWebhookSQL  = "INSERT INTO webhooks (url, events, secret, selector, created) VALUES (?, ?, ?, ?, " + nowSQL + ")"
+	getWebhooksSQL    = "SELECT id, url, events, secret, selector, created FROM webhooks ORDER BY id"
+	getWebhookSQL     = "SELECT id, url, events, secret, selector, created FROM webhooks WHERE id=?"
+	deleteWebhookSQL  = "DELETE FROM webhooks WHERE id=?"
+	createDeliverySQL = `INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, attempt, status_code, error, duration_ms, delivered)
+							VALUES (?, ?, ?, ?, ?, ?, ?, ` + nowSQL + `)`
+	getDeliveriesSQL = `SELECT id, event_id, event_type, attempt, status_code, error, duration_ms, delivered FROM webhook_deliveries
+							WHERE webhook_id=? ORDER BY id DESC LIMIT ?`
+	deleteDeliveriesSQL = "DELETE FROM webhook_deliveries WHERE webhook_id=?"
+	// Only the last deliveries of each webhook are kept.
+	pruneDeliveriesSQL = `DELETE FROM webhook_deliveries WHERE webhook_id=? AND id <= (
+							SELECT id FROM webhook_deliveries WHERE webhook_id=? ORDER BY id DESC LIMIT 1 OFFSET ?)`
 )
 
-func createOrUpdateSwDoc(db *sql.DB, swdoc *SwDoc) error {
+// dbMigrations bring the database schema up to date, they are applied in
+// order and only once. New migrations must be appended to the end.
+var dbMigrations = []string{
+	dbSchema,
+	"ALTER TABLE swdocs ADD COLUMN labels TEXT",
+	"ALTER TABLE swdocs ADD COLUMN revision INTEGER NOT NULL DEFAULT 1",
+	"CREATE TABLE IF NOT EXISTS swdoc_redirects (old_name TEXT PRIMARY KEY, new_name TEXT NOT NULL)",
+	"CREATE TABLE IF NOT EXISTS swdoc_aliases (alias TEXT PRIMARY KEY, name TEXT NOT NULL)",
+	`CREATE TABLE IF NOT EXISTS link_clicks (
+		name TEXT NOT NULL,
+		link_id TEXT NOT NULL,
+		url TEXT NOT NULL,
+		clicks INTEGER NOT NULL DEFAULT 0,
+		last_clicked TEXT,
+		PRIMARY KEY (name, link_id))`,
+	`CREATE TABLE IF NOT EXISTS link_status (
+		url TEXT PRIMARY KEY,
+		status_code INTEGER NOT NULL DEFAULT 0,
+		latency_ms INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		last_checked TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP)`,
+	`CREATE TABLE IF NOT EXISTS events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		type TEXT NOT NULL,
+		name TEXT NOT NULL,
+		old_name TEXT NOT NULL DEFAULT '',
+		revision INTEGER NOT NULL DEFAULT 0,
+		user TEXT NOT NULL DEFAULT '',
+		swdoc TEXT,
+		created TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP)`,
+	`CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL,
+		events TEXT NOT NULL DEFAULT '',
+		secret TEXT NOT NULL DEFAULT '',
+		selector TEXT NOT NULL DEFAULT '',
+		created TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP)`,
+	`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL,
+		event_id INTEGER NOT NULL,
+		event_type TEXT NOT NULL,
+		attempt INTEGER NOT NULL,
+		status_code INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		duration_ms INTEGER NOT NULL DEFAULT 0,
+		delivered TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP)`,
+	"ALTER TABLE swdocs ADD COLUMN deleted_at TEXT",
+	"ALTER TABLE swdocs ADD COLUMN deleted_by TEXT NOT NULL DEFAULT ''",
+	`CREATE TABLE IF NOT EXISTS swdoc_relations (
+		name TEXT NOT NULL,
+		related TEXT NOT NULL,
+		type TEXT NOT NULL,
+		PRIMARY KEY (name, related, type))`,
+	// Timestamps are stored as RFC 3339 in UTC instead of the format of CURRENT_TIMESTAMP.
+	`UPDATE swdocs SET created=strftime('%Y-%m-%dT%H:%M:%SZ', created), updated=strftime('%Y-%m-%dT%H:%M:%SZ', updated),
+		deleted_at=strftime('%Y-%m-%dT%H:%M:%SZ', deleted_at);
+	UPDATE link_clicks SET last_clicked=strftime('%Y-%m-%dT%H:%M:%SZ', last_clicked);
+	UPDATE link_status SET last_checked=strftime('%Y-%m-%dT%H:%M:%SZ', last_checked);
+	UPDATE events SET created=strftime('%Y-%m-%dT%H:%M:%SZ', created);
+	UPDATE webhooks SET created=strftime('%Y-%m-%dT%H:%M:%SZ', created);
+	UPDATE webhook_deliveries SET delivered=strftime('%Y-%m-%dT%H:%M:%SZ', delivered)`,
+}
+
+// conflictError is returned when a name or alias is already taken by another SwDoc.
+type conflictError string
+
+func (e conflictError) Error() string {
+	return string(e)
+}
+
+// sqlExecutor is implemented by both *sql.DB and *sql.Tx so the same
+// functions can be used inside and outside of a transaction.
+type sqlExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Prepare(query string) (*sql.Stmt, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func createOrUpdateSwDoc(db sqlExecutor, swdoc *SwDoc) error {
 	statement, err := db.Prepare(createOrUpdateSwDocSQL)
 	if err != nil {
 		return err
 	}
 
-	res, err := statement.Exec(swdoc.Name, swdoc.Description, swdoc.User, swdoc.Sections)
+	res, err := statement.Exec(swdoc.Name, swdoc.Description, swdoc.User, swdoc.Sections, swdoc.Labels)
 	if err != nil {
 		return err
 	}
@@ -41,9 +227,119 @@ func createOrUpdateSwDoc(db *sql.DB, swdoc *SwDoc) error {
 	lid, err := res.LastInsertId()
 	swdoc.ID = lid
 
+	// And the revision, which is bumped on every update.
+	return db.QueryRow(getSwDocRevisionSQL, swdoc.Name).Scan(&swdoc.Revision)
+}
+
+// checkSwDocNames verifies neither the name nor the aliases of swdoc are
+// taken by another SwDoc, names and aliases share the same namespace. The
+// name of a SwDoc in the trash stays taken until it is purged, so applying
+// doesn't silently overwrite what could be restored.
+func checkSwDocNames(db sqlExecutor, swdoc *SwDoc) error {
+	trashed, err := isTrashed(db, swdoc.Name)
+	if err != nil {
+		return err
+	}
+	if trashed {
+		return conflictError("A SwDoc called " + swdoc.Name + " is in the trash, restore or purge it first")
+	}
+
+	owner, err := getAliasOwner(db, swdoc.Name)
+	if err != nil {
+		return err
+	}
+	if owner != "" && owner != swdoc.Name {
+		return conflictError(swdoc.Name + " is already an alias of " + owner)
+	}
+
+	for _, alias := range swdoc.Aliases {
+		exists, err := swDocExists(db, alias)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return conflictError("alias " + alias + " is already the name of another SwDoc")
+		}
+
+		owner, err := getAliasOwner(db, alias)
+		if err != nil {
+			return err
+		}
+		if owner != "" && owner != swdoc.Name {
+			return conflictError("alias " + alias + " is already an alias of " + owner)
+		}
+	}
 	return nil
 }
 
+// applySwDoc creates or updates swdoc and reports which of the two happened.
+// A SwDoc applied as it is stored is left untouched, without an event.
+func applySwDoc(db sqlExecutor, swdoc *SwDoc) (string, error) {
+	if err := checkSwDocNames(db, swdoc); err != nil {
+		return "", err
+	}
+
+	stored, err := getSwDocByName(db, swdoc.Name)
+	if err != nil {
+		return "", err
+	}
+	exists := stored.Name != ""
+	if exists {
+		changes, err := diffSwDocs(&stored, swdoc)
+		if err != nil {
+			return "", err
+		}
+		if len(changes) == 0 {
+			swdoc.Revision = stored.Revision
+			swdoc.Created = stored.Created
+			swdoc.Updated = stored.Updated
+			return ActionUnchanged, nil
+		}
+	}
+
+	if err := createOrUpdateSwDoc(db, swdoc); err != nil {
+		return "", err
+	}
+
+	if _, err := db.Exec(deleteAliasesSQL, swdoc.Name); err != nil {
+		return "", err
+	}
+	for _, alias := range swdoc.Aliases {
+		// The alias may still belong to a SwDoc in the trash.
+		if _, err := db.Exec(deleteAliasSQL, alias); err != nil {
+			return "", err
+		}
+		if _, err := db.Exec(createAliasSQL, alias, swdoc.Name); err != nil {
+			return "", err
+		}
+	}
+
+	if _, err := db.Exec(deleteRelationsSQL, swdoc.Name); err != nil {
+		return "", err
+	}
+	for _, rel := range swdoc.Related {
+		if _, err := db.Exec(createRelationSQL, swdoc.Name, rel.Name, rel.Type); err != nil {
+			return "", err
+		}
+	}
+
+	// The name and aliases are in use again, they can't redirect to a renamed SwDoc anymore.
+	for _, name := range append([]string{swdoc.Name}, swdoc.Aliases...) {
+		if _, err := db.Exec(deleteRedirectSQL, name); err != nil {
+			return "", err
+		}
+	}
+
+	action, eventType := ActionCreated, EventCreated
+	if exists {
+		action, eventType = ActionUpdated, EventUpdated
+	}
+	if err := recordEvent(db, eventType, swdoc.Name, "", swdoc.User); err != nil {
+		return "", err
+	}
+	return action, nil
+}
+
 func getMostRecentCreatedSwDocs(db *sql.DB) ([]SwDoc, error) {
 	rows, err := db.Query(getRecentCreatedSwDocSQL)
 	if err != nil {
@@ -84,7 +380,15 @@ func getMostRecentUpdatedSwDocs(db *sql.DB) ([]SwDoc, error) {
 	return docs, nil
 }
 
-func getSwDocByName(db *sql.DB, name string) (SwDoc, error) {
+func swDocExists(db sqlExecutor, name string) (bool, error) {
+	var count int
+	if err := db.QueryRow(swDocExistsSQL, name).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func getSwDocByName(db sqlExecutor, name string) (SwDoc, error) {
 	var s SwDoc
 	statement, err := db.Prepare(getSwDocSQL)
 	if err != nil {
@@ -98,13 +402,140 @@ func getSwDocByName(db *sql.DB, name string) (SwDoc, error) {
 
 	defer rows.Close()
 	for rows.Next() {
-		if err := rows.Scan(&s.Name, &s.Description, &s.Sections, &s.User, &s.Updated); err != nil {
+		if err := rows.Scan(&s.Name, &s.Description, &s.Sections, &s.Labels, &s.Aliases, &s.User, &s.Revision, &s.Created, &s.Updated); err != nil {
 			return s, err
 		}
 	}
+	if s.Name == "" {
+		return s, nil
+	}
+
+	s.Related, err = getRelations(db, s.Name)
+	return s, err
+}
+
+// getRelations returns the relations of the SwDoc called name, in the order they were given.
+func getRelations(db sqlExecutor, name string) ([]Relation, error) {
+	rows, err := db.Query(getRelationsSQL, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var relations []Relation
+	for rows.Next() {
+		var rel Relation
+		if err := rows.Scan(&rel.Name, &rel.Type); err != nil {
+			return nil, err
+		}
+		relations = append(relations, rel)
+	}
+
+	return relations, nil
+}
+
+// getRelatedSwDocs returns the SwDocs the one called name is related to and
+// the ones related to it, leaving out the ones in the trash.
+func getRelatedSwDocs(db sqlExecutor, name string) (RelatedSwDocs, error) {
+	related := RelatedSwDocs{Name: name}
+	var err error
+	if related.Related, err = queryRelatedSwDocs(db, getRelatedSQL, name); err != nil {
+		return related, err
+	}
+	related.ReferencedBy, err = queryRelatedSwDocs(db, getReferencedBySQL, name)
+	return related, err
+}
+
+func queryRelatedSwDocs(db sqlExecutor, query, name string) ([]RelatedSwDoc, error) {
+	rows, err := db.Query(query, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	docs := []RelatedSwDoc{}
+	for rows.Next() {
+		var d RelatedSwDoc
+		if err := rows.Scan(&d.Name, &d.Type, &d.Description); err != nil {
+			return nil, err
+		}
+		docs = append(docs, d)
+	}
+
+	return docs, nil
+}
+
+// getGraph returns the graph of the relations between every SwDoc out of the trash.
+func getGraph(db sqlExecutor) (Graph, error) {
+	rows, err := db.Query(getGraphNodesSQL)
+	if err != nil {
+		return Graph{}, err
+	}
+	defer rows.Close()
+
+	nodes := []GraphNode{}
+	for rows.Next() {
+		var n GraphNode
+		if err := rows.Scan(&n.Name, &n.Description); err != nil {
+			return Graph{}, err
+		}
+		nodes = append(nodes, n)
+	}
 
-	return s, nil
+	relRows, err := db.Query(getAllRelationsSQL)
+	if err != nil {
+		return Graph{}, err
+	}
+	defer relRows.Close()
+
+	relations := make(map[string][]Relation)
+	for relRows.Next() {
+		var name string
+		var rel Relation
+		if err := relRows.Scan(&name, &rel.Name, &rel.Type); err != nil {
+			return Graph{}, err
+		}
+		relations[name] = append(relations[name], rel)
+	}
 
+	return newGraph(nodes, relations), nil
+}
+
+// checkRelations verifies the SwDocs swdoc is related to exist, or are in
+// pending as they are applied along with it.
+func checkRelations(db sqlExecutor, swdoc *SwDoc, pending map[string]bool) error {
+	var errs ValidationErrors
+	for i, rel := range swdoc.Related {
+		if pending[rel.Name] {
+			continue
+		}
+		exists, err := swDocExists(db, rel.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		field := fmt.Sprintf("related[%d].name", i)
+		canonical, permanent, err := resolveSwDocName(db, rel.Name)
+		if err != nil {
+			return err
+		}
+		switch {
+		case canonical == "":
+			errs.add(field, "there is no SwDoc called %q", rel.Name)
+		case permanent:
+			errs.add(field, "%q was renamed to %q", rel.Name, canonical)
+		default:
+			errs.add(field, "%q is an alias of %q, use its name", rel.Name, canonical)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
 }
 
 func searchSwDocsByName(db *sql.DB, name string) ([]SwDoc, error) {
@@ -115,7 +546,7 @@ func searchSwDocsByName(db *sql.DB, name string) ([]SwDoc, error) {
 		return docs, err
 	}
 
-	rows, err := statement.Query(name)
+	rows, err := statement.Query(name, name)
 	if err != nil {
 		return docs, err
 	}
@@ -124,26 +555,527 @@ func searchSwDocsByName(db *sql.DB, name string) ([]SwDoc, error) {
 
 	for rows.Next() {
 		var s SwDoc
-		if err := rows.Scan(&s.Name, &s.User, &s.Updated); err != nil {
+		if err := rows.Scan(&s.Name, &s.Labels, &s.Aliases, &s.User, &s.Updated); err != nil {
+			return nil, err
+		}
+		docs = append(docs, s)
+	}
+
+	return docs, nil
+
+}
+
+// deleteSwDoc moves the SwDoc called name, if there is one, to the trash on behalf of user.
+func deleteSwDoc(db sqlExecutor, name, user string) error {
+	doc, err := getSwDocByName(db, name)
+	if err != nil || doc.Name == "" {
+		return err
+	}
+
+	if _, err := db.Exec(trashSwDocSQL, user, name); err != nil {
+		return err
+	}
+
+	return createEvent(db, EventDeleted, doc, "", user)
+}
+
+// getTrash returns the SwDocs in the trash, the most recently deleted first.
+func getTrash(db sqlExecutor) ([]SwDoc, error) {
+	rows, err := db.Query(getTrashSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	docs := []SwDoc{}
+	for rows.Next() {
+		var s SwDoc
+		if err := rows.Scan(&s.Name, &s.Description, &s.Aliases, &s.User, &s.Revision, &s.Updated, &s.Deleted, &s.DeletedBy); err != nil {
 			return nil, err
 		}
 		docs = append(docs, s)
 	}
 
 	return docs, nil
+}
 
+func isTrashed(db sqlExecutor, name string) (bool, error) {
+	var count int
+	if err := db.QueryRow(isTrashedSQL, name).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
 }
 
-func deleteSwDoc(db *sql.DB, name string) error {
-	statement, err := db.Prepare(deleteSwDocSQL)
+// restoreSwDoc takes the SwDoc called name out of the trash on behalf of user.
+// The aliases taken by other SwDocs meanwhile aren't restored.
+func restoreSwDoc(db sqlExecutor, name, user string) error {
+	owner, err := getAliasOwner(db, name)
+	if err != nil {
+		return err
+	}
+	if owner != "" {
+		return conflictError(name + " is now an alias of " + owner)
+	}
+
+	if _, err := db.Exec(restoreSwDocSQL, user, name); err != nil {
+		return err
+	}
+
+	doc, err := getSwDocByName(db, name)
 	if err != nil {
 		return err
 	}
+	// The name and aliases are in use again, they can't redirect to a renamed SwDoc anymore.
+	for _, n := range append([]string{doc.Name}, doc.Aliases...) {
+		if _, err := db.Exec(deleteRedirectSQL, n); err != nil {
+			return err
+		}
+	}
 
-	_, err = statement.Exec(name)
+	return createEvent(db, EventRestored, doc, "", user)
+}
+
+// purgeSwDoc deletes the SwDoc called name from the trash for good.
+func purgeSwDoc(db sqlExecutor, name string) error {
+	res, err := db.Exec(purgeSwDocSQL, name)
 	if err != nil {
 		return err
 	}
+	// A SwDoc which isn't in the trash keeps its aliases and clicks.
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
 
-	return nil
+	if _, err := db.Exec(deleteAliasesSQL, name); err != nil {
+		return err
+	}
+	if _, err := db.Exec(purgeRelationsSQL, name, name); err != nil {
+		return err
+	}
+	// The old names of the SwDoc would redirect to nothing.
+	if _, err := db.Exec(deleteRedirectsToSQL, name); err != nil {
+		return err
+	}
+	_, err = db.Exec(deleteLinkClicksSQL, name)
+	return err
+}
+
+// purgeTrash deletes for good the SwDocs moved to the trash before the given
+// time and returns their names.
+func purgeTrash(db sqlExecutor, before time.Time) ([]string, error) {
+	rows, err := db.Query(getTrashedBeforeSQL, timeStamp(before))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+
+	for _, name := range names {
+		if err := purgeSwDoc(db, name); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// renameSwDoc renames a SwDoc and makes its old name, and any name
+// redirecting to it, redirect to the new name.
+func renameSwDoc(db sqlExecutor, oldName, newName, user string) error {
+	// The SwDoc may be renamed to one of its aliases.
+	if _, err := db.Exec(deleteAliasSQL, newName); err != nil {
+		return err
+	}
+	if _, err := db.Exec(renameSwDocSQL, newName, user, oldName); err != nil {
+		return err
+	}
+	if _, err := db.Exec(renameAliasesSQL, newName, oldName); err != nil {
+		return err
+	}
+	if _, err := db.Exec(renameLinkClicksSQL, newName, oldName); err != nil {
+		return err
+	}
+	// The SwDocs related to it follow the new name.
+	if _, err := db.Exec(renameRelationsSQL, newName, oldName); err != nil {
+		return err
+	}
+	if _, err := db.Exec(renameRelatedSQL, newName, oldName); err != nil {
+		return err
+	}
+	if _, err := db.Exec(updateRedirectsSQL, newName, oldName); err != nil {
+		return err
+	}
+	if _, err := db.Exec(deleteRedirectSQL, newName); err != nil {
+		return err
+	}
+	if _, err := db.Exec(createRedirectSQL, oldName, newName); err != nil {
+		return err
+	}
+	return recordEvent(db, EventRenamed, newName, oldName, user)
+}
+
+// getRedirect returns the name a renamed SwDoc got, or "" if name was never renamed.
+func getRedirect(db sqlExecutor, name string) (string, error) {
+	var newName string
+	err := db.QueryRow(getRedirectSQL, name).Scan(&newName)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return newName, err
+}
+
+// getAliasOwner returns the name of the SwDoc with the given alias, or "" if there is none.
+func getAliasOwner(db sqlExecutor, alias string) (string, error) {
+	var name string
+	err := db.QueryRow(getAliasOwnerSQL, alias).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return name, err
+}
+
+// resolveSwDocName finds the canonical name of a SwDoc from one of its
+// aliases or from an old name, permanent is set for the latter.
+// An empty name is returned if nothing is known by that name.
+func resolveSwDocName(db sqlExecutor, name string) (canonical string, permanent bool, err error) {
+	canonical, err = getAliasOwner(db, name)
+	if err != nil || canonical != "" {
+		return canonical, false, err
+	}
+	canonical, err = getRedirect(db, name)
+	return canonical, canonical != "", err
+}
+
+func recordLinkClick(db sqlExecutor, name string, l link) error {
+	_, err := db.Exec(recordLinkClickSQL, name, l.ID(), l.URL)
+	return err
+}
+
+// getLinkClicks returns the clicks of the links of a SwDoc by link ID.
+func getLinkClicks(db sqlExecutor, name string) (map[string]linkStats, error) {
+	rows, err := db.Query(getLinkClicksSQL, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clicks := make(map[string]linkStats)
+	for rows.Next() {
+		var l linkStats
+		if err := rows.Scan(&l.ID, &l.Clicks, &l.LastClicked); err != nil {
+			return nil, err
+		}
+		clicks[l.ID] = l
+	}
+
+	return clicks, nil
+}
+
+func getMostVisitedSwDocs(db sqlExecutor, limit int) ([]swDocStats, error) {
+	rows, err := db.Query(getMostVisitedSQL, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []swDocStats
+	for rows.Next() {
+		var s swDocStats
+		if err := rows.Scan(&s.Name, &s.Clicks); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+
+	return stats, nil
+}
+
+// getStaleSwDocs returns the SwDocs last updated before the given time, oldest first.
+func getStaleSwDocs(db sqlExecutor, before time.Time) ([]SwDoc, error) {
+	rows, err := db.Query(getStaleSwDocsSQL, timeStamp(before))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var docs []SwDoc
+	for rows.Next() {
+		var s SwDoc
+		if err := rows.Scan(&s.Name, &s.Description, &s.Labels, &s.User, &s.Updated); err != nil {
+			return nil, err
+		}
+		s.Stale = true
+		docs = append(docs, s)
+	}
+
+	return docs, nil
+}
+
+// getAllSwDocSections returns every SwDoc with only its name and sections.
+func getAllSwDocSections(db sqlExecutor) ([]SwDoc, error) {
+	rows, err := db.Query(getAllSwDocSectionsSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var docs []SwDoc
+	for rows.Next() {
+		var s SwDoc
+		if err := rows.Scan(&s.Name, &s.Sections); err != nil {
+			return nil, err
+		}
+		docs = append(docs, s)
+	}
+
+	return docs, nil
+}
+
+// getAllSwDocs returns every SwDoc sorted by name, without their sections.
+func getAllSwDocs(db sqlExecutor) ([]SwDoc, error) {
+	return querySwDocList(db, getAllSwDocsSQL)
+}
+
+// getUserSwDocs returns the SwDocs last updated by user, most recent first,
+// without their sections.
+func getUserSwDocs(db sqlExecutor, user string) ([]SwDoc, error) {
+	return querySwDocList(db, getUserSwDocsSQL, user)
+}
+
+// getSwDocsWithoutEvents returns the SwDocs without recorded events, most
+// recently updated first, without their sections.
+func getSwDocsWithoutEvents(db sqlExecutor) ([]SwDoc, error) {
+	rows, err := db.Query(getSwDocsWithoutEventsSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var docs []SwDoc
+	for rows.Next() {
+		var s SwDoc
+		if err := rows.Scan(&s.Name, &s.Description, &s.Labels, &s.User, &s.Revision, &s.Updated); err != nil {
+			return nil, err
+		}
+		docs = append(docs, s)
+	}
+
+	return docs, nil
+}
+
+func querySwDocList(db sqlExecutor, query string, args ...interface{}) ([]SwDoc, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var docs []SwDoc
+	for rows.Next() {
+		var s SwDoc
+		if err := rows.Scan(&s.Name, &s.Description, &s.Labels, &s.Aliases, &s.User, &s.Updated); err != nil {
+			return nil, err
+		}
+		docs = append(docs, s)
+	}
+
+	return docs, nil
+}
+
+func saveLinkStatus(db sqlExecutor, status LinkStatus) error {
+	_, err := db.Exec(saveLinkStatusSQL, status.URL, status.StatusCode, status.LatencyMs, status.Error)
+	return err
+}
+
+// getLinkStatuses returns the status of every checked link by URL.
+func getLinkStatuses(db sqlExecutor) (map[string]LinkStatus, error) {
+	rows, err := db.Query(getLinkStatusesSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statuses := make(map[string]LinkStatus)
+	for rows.Next() {
+		var s LinkStatus
+		if err := rows.Scan(&s.URL, &s.StatusCode, &s.LatencyMs, &s.Error, &s.LastChecked); err != nil {
+			return nil, err
+		}
+		statuses[s.URL] = s
+	}
+
+	return statuses, nil
+}
+
+// recordEvent stores a change to the SwDoc called name along with how it looks
+// now. It must run in the transaction of the change so only committed changes have events.
+func recordEvent(db sqlExecutor, eventType, name, oldName, user string) error {
+	doc, err := getSwDocByName(db, name)
+	if err != nil {
+		return err
+	}
+	return createEvent(db, eventType, doc, oldName, user)
+}
+
+func createEvent(db sqlExecutor, eventType string, doc SwDoc, oldName, user string) error {
+	snapshot, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(createEventSQL, eventType, doc.Name, oldName, doc.Revision, user, string(snapshot))
+	return err
+}
+
+// getEventsAfter returns up to limit events newer than the event with the given id, oldest first.
+func getEventsAfter(db sqlExecutor, id int64, limit int) ([]Event, error) {
+	return queryEvents(db, getEventsAfterSQL, id, limit)
+}
+
+// getEventsBefore returns up to limit events older than the event with the given id, newest first.
+func getEventsBefore(db sqlExecutor, id int64, limit int) ([]Event, error) {
+	return queryEvents(db, getEventsBeforeSQL, id, limit)
+}
+
+// getUserEvents returns the last limit events made by user, newest first and
+// without the SwDoc they changed.
+func getUserEvents(db sqlExecutor, user string, limit int) ([]Event, error) {
+	return queryEvents(db, getUserEventsSQL, user, limit)
+}
+
+// getSwDocEvents returns the last limit events of the SwDoc called name,
+// including its renaming from or to name, newest first.
+func getSwDocEvents(db sqlExecutor, name string, limit int) ([]Event, error) {
+	return queryEvents(db, getSwDocEventsSQL, name, name, limit)
+}
+
+func queryEvents(db sqlExecutor, query string, args ...interface{}) ([]Event, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var e Event
+		var snapshot sql.NullString
+		if err := rows.Scan(&e.ID, &e.Type, &e.Name, &e.OldName, &e.Revision, &e.User, &snapshot, &e.Created); err != nil {
+			return nil, err
+		}
+		if snapshot.Valid {
+			e.SwDoc = &SwDoc{}
+			if err := json.Unmarshal([]byte(snapshot.String), e.SwDoc); err != nil {
+				return nil, err
+			}
+		}
+		events = append(events, e)
+	}
+
+	return events, nil
+}
+
+func getLastEventID(db sqlExecutor) (int64, error) {
+	var id int64
+	err := db.QueryRow(getLastEventIDSQL).Scan(&id)
+	return id, err
+}
+
+func createWebhook(db sqlExecutor, hook *Webhook) error {
+	res, err := db.Exec(createWebhookSQL, hook.URL, strings.Join(hook.Events, ","), hook.Secret, hook.Selector)
+	if err != nil {
+		return err
+	}
+	hook.ID, err = res.LastInsertId()
+	return err
+}
+
+func scanWebhook(rows *sql.Rows) (Webhook, error) {
+	var hook Webhook
+	var events string
+	if err := rows.Scan(&hook.ID, &hook.URL, &events, &hook.Secret, &hook.Selector, &hook.Created); err != nil {
+		return hook, err
+	}
+	if events != "" {
+		hook.Events = strings.Split(events, ",")
+	}
+	return hook, nil
+}
+
+func getWebhooks(db sqlExecutor) ([]Webhook, error) {
+	rows, err := db.Query(getWebhooksSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hooks []Webhook
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+
+	return hooks, nil
+}
+
+// getWebhook returns the webhook with the given id, its ID is 0 if there is none.
+func getWebhook(db sqlExecutor, id int64) (Webhook, error) {
+	rows, err := db.Query(getWebhookSQL, id)
+	if err != nil {
+		return Webhook{}, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return scanWebhook(rows)
+	}
+	return Webhook{}, nil
+}
+
+func deleteWebhook(db sqlExecutor, id int64) error {
+	if _, err := db.Exec(deleteWebhookSQL, id); err != nil {
+		return err
+	}
+	_, err := db.Exec(deleteDeliveriesSQL, id)
+	return err
+}
+
+// saveDelivery logs a delivery attempt, only the last keep of the webhook are kept.
+func saveDelivery(db sqlExecutor, d WebhookDelivery, keep int) error {
+	if _, err := db.Exec(createDeliverySQL, d.WebhookID, d.EventID, d.EventType, d.Attempt, d.StatusCode, d.Error, d.DurationMs); err != nil {
+		return err
+	}
+	_, err := db.Exec(pruneDeliveriesSQL, d.WebhookID, d.WebhookID, keep)
+	return err
+}
+
+// getDeliveries returns the last limit delivery attempts of a webhook, newest first.
+func getDeliveries(db sqlExecutor, webhookID int64, limit int) ([]WebhookDelivery, error) {
+	rows, err := db.Query(getDeliveriesSQL, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		d := WebhookDelivery{WebhookID: webhookID}
+		if err := rows.Scan(&d.ID, &d.EventID, &d.EventType, &d.Attempt, &d.StatusCode, &d.Error, &d.DurationMs, &d.Delivered); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, nil
 }
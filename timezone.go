@@ -0,0 +1,93 @@
+package swdocs
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+)
+
+// tzCookie keeps the timezone picked with ?tz= for the next pages.
+const tzCookie = "swdocs_tz"
+
+// dateTimeLayout is how dates are shown to people, along with their timezone.
+const dateTimeLayout = "2006-01-02 15:04 MST"
+
+// timezone returns where the dates of the page are shown: the timezone of
+// ?tz=, which is remembered, or the one remembered before, or the default one.
+func (a *App) timezone(w http.ResponseWriter, r *http.Request) *time.Location {
+	if name := r.URL.Query().Get("tz"); name != "" {
+		if loc, err := time.LoadLocation(name); err == nil {
+			http.SetCookie(w, &http.Cookie{
+				Name:     tzCookie,
+				Value:    loc.String(),
+				Path:     "/",
+				Expires:  time.Now().AddDate(1, 0, 0),
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+			return loc
+		}
+	}
+	if c, err := r.Cookie(tzCookie); err == nil {
+		if loc, err := time.LoadLocation(c.Value); err == nil {
+			return loc
+		}
+	}
+	if a.Config.Timezone != nil {
+		return a.Config.Timezone
+	}
+	return time.UTC
+}
+
+// timeFuncs are the functions of the templates showing dates in loc.
+func timeFuncs(loc *time.Location) template.FuncMap {
+	return template.FuncMap{
+		"datetime": func(t *timeStamp) string {
+			if t == nil {
+				return ""
+			}
+			return t.Format(loc)
+		},
+		"ago": func(t *timeStamp) string {
+			if t == nil {
+				return ""
+			}
+			return t.Ago()
+		},
+		"timezone": loc.String,
+	}
+}
+
+// relativeTime describes how long ago, or in how long, t is from now.
+func relativeTime(t, now time.Time) string {
+	d := now.Sub(t)
+	future := d < 0
+	if future {
+		d = -d
+	}
+
+	var n int
+	var unit string
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		n, unit = int(d/time.Minute), "minute"
+	case d < 24*time.Hour:
+		n, unit = int(d/time.Hour), "hour"
+	case d < 30*24*time.Hour:
+		n, unit = int(d/(24*time.Hour)), "day"
+	case d < 365*24*time.Hour:
+		n, unit = int(d/(30*24*time.Hour)), "month"
+	default:
+		n, unit = int(d/(365*24*time.Hour)), "year"
+	}
+	if n > 1 {
+		unit += "s"
+	}
+	if future {
+		return fmt.Sprintf("in %d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %s ago", n, unit)
+}
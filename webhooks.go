@@ -0,0 +1,254 @@
+package swdocs
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Headers sent along with every webhook delivery.
+const (
+	EventHeader     = "X-Swdocs-Event"
+	DeliveryHeader  = "X-Swdocs-Delivery"
+	SignatureHeader = "X-Swdocs-Signature"
+)
+
+// EventPing is only sent to test a webhook, it isn't about any SwDoc.
+const EventPing = "ping"
+
+// deliveriesKept is how many delivery attempts are logged per webhook.
+const deliveriesKept = 100
+
+// Webhook subscribes a URL to the events of the SwDocs matching Selector.
+// Empty Events subscribes to every type of event.
+type Webhook struct {
+	ID       int64      `json:"id,omitempty"`
+	URL      string     `json:"url"`
+	Events   []string   `json:"events,omitempty"`
+	Secret   string     `json:"secret,omitempty"`
+	Selector string     `json:"selector,omitempty"`
+	Created  *timeStamp `json:"created,omitempty"`
+}
+
+// WebhookDelivery is the log of an attempt to deliver an event to a webhook.
+type WebhookDelivery struct {
+	ID         int64      `json:"id"`
+	WebhookID  int64      `json:"webhookId"`
+	EventID    int64      `json:"eventId"`
+	EventType  string     `json:"eventType"`
+	Attempt    int        `json:"attempt"`
+	StatusCode int        `json:"statusCode,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	DurationMs int64      `json:"durationMs"`
+	Delivered  *timeStamp `json:"delivered,omitempty"`
+}
+
+// Succeeded tells whether the receiver accepted the event.
+func (d WebhookDelivery) Succeeded() bool {
+	return d.Error == "" && d.StatusCode >= 200 && d.StatusCode < 300
+}
+
+// Validate checks the webhook is well formed before it is stored.
+func (h *Webhook) Validate() error {
+	var errs ValidationErrors
+
+	if u, err := url.Parse(h.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs.add("url", "must be an absolute http(s) URL")
+	}
+
+	for i, e := range h.Events {
+		known := false
+		for _, t := range eventTypes {
+			known = known || e == t
+		}
+		if !known {
+			errs.add(fmt.Sprintf("events[%d]", i), "unknown event %q, use one of %v", e, eventTypes)
+		}
+	}
+
+	if _, err := ParseSelector(h.Selector); err != nil {
+		errs.add("selector", err.Error())
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// wants tells whether the event must be delivered to the webhook.
+func (h *Webhook) wants(e Event) bool {
+	if len(h.Events) > 0 {
+		subscribed := false
+		for _, t := range h.Events {
+			subscribed = subscribed || t == e.Type
+		}
+		if !subscribed {
+			return false
+		}
+	}
+
+	sel, err := ParseSelector(h.Selector)
+	return err == nil && e.SwDoc != nil && e.matches(sel)
+}
+
+// SignPayload is the value of the signature header of a payload, receivers
+// compute it with their copy of the secret and compare it with hmac.Equal.
+func SignPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookDispatcher delivers the events recorded in the database to the
+// webhooks subscribed to them, retrying with an exponential backoff.
+type WebhookDispatcher struct {
+	DB *sql.DB
+	// Mutex is the one of the App, sqlite only allows one writer at a time.
+	Mutex  *sync.Mutex
+	Client *http.Client
+	// Retries is how many times a failed delivery is retried, waiting
+	// Backoff before the first retry and twice as long before each next one.
+	Retries int
+	Backoff time.Duration
+
+	wake chan struct{}
+}
+
+// NewWebhookClient returns the client delivering events. Unless allowPrivate
+// is set it refuses to connect to private addresses, so registering a webhook
+// can't be used to probe the internal network.
+func NewWebhookClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	return newGuardedClient(timeout, allowPrivate)
+}
+
+// NewWebhookDispatcher creates a dispatcher, it delivers nothing until Run.
+func NewWebhookDispatcher(db *sql.DB, mutex *sync.Mutex, client *http.Client, retries int, backoff time.Duration) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		DB:      db,
+		Mutex:   mutex,
+		Client:  client,
+		Retries: retries,
+		Backoff: backoff,
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Notify tells the dispatcher there are new events, it never blocks.
+func (d *WebhookDispatcher) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers the events recorded after it started, forever. Events recorded
+// while swdocs was down aren't delivered.
+func (d *WebhookDispatcher) Run() {
+	lastID, err := getLastEventID(d.DB)
+	if err != nil {
+		log.Error("Webhook dispatcher failed to start: " + err.Error())
+		return
+	}
+
+	for range d.wake {
+		for {
+			events, err := getEventsAfter(d.DB, lastID, 100)
+			if err != nil {
+				log.Error("Webhook dispatcher failed to read events: " + err.Error())
+				break
+			}
+			if len(events) == 0 {
+				break
+			}
+
+			hooks, err := getWebhooks(d.DB)
+			if err != nil {
+				log.Error("Webhook dispatcher failed to read webhooks: " + err.Error())
+				break
+			}
+
+			for _, e := range events {
+				lastID = e.ID
+				for _, hook := range hooks {
+					if hook.wants(e) {
+						go d.deliver(hook, e)
+					}
+				}
+			}
+		}
+	}
+}
+
+// deliver sends the event to the webhook until it is accepted or the retries run out.
+// The pending retries only live in this goroutine, they are lost on restart.
+func (d *WebhookDispatcher) deliver(hook Webhook, e Event) {
+	wait := d.Backoff
+	for attempt := 1; attempt <= d.Retries+1; attempt++ {
+		if attempt > 1 {
+			time.Sleep(wait)
+			wait *= 2
+		}
+		if d.Send(hook, e, attempt).Succeeded() {
+			return
+		}
+	}
+	log.WithFields(log.Fields{
+		"webhook": hook.ID,
+		"event":   e.ID,
+	}).Error("Giving up delivering event to " + hook.URL)
+}
+
+// Send makes a single delivery attempt of the event to the webhook and logs it.
+func (d *WebhookDispatcher) Send(hook Webhook, e Event, attempt int) WebhookDelivery {
+	delivery := WebhookDelivery{WebhookID: hook.ID, EventID: e.ID, EventType: e.Type, Attempt: attempt}
+	start := time.Now()
+	delivery.StatusCode, delivery.Error = d.post(hook, e)
+	delivery.DurationMs = time.Since(start).Milliseconds()
+
+	d.Mutex.Lock()
+	defer d.Mutex.Unlock()
+	if err := saveDelivery(d.DB, delivery, deliveriesKept); err != nil {
+		log.Error("Failed to log the delivery to " + hook.URL + ": " + err.Error())
+	}
+	return delivery
+}
+
+func (d *WebhookDispatcher) post(hook Webhook, e Event) (int, string) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return 0, err.Error()
+	}
+
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err.Error()
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "swdocs-webhooks")
+	req.Header.Set(EventHeader, e.Type)
+	req.Header.Set(DeliveryHeader, fmt.Sprintf("%d", e.ID))
+	if hook.Secret != "" {
+		req.Header.Set(SignatureHeader, SignPayload(hook.Secret, payload))
+	}
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	io.CopyN(ioutil.Discard, resp.Body, 4096)
+	resp.Body.Close()
+	return resp.StatusCode, ""
+}
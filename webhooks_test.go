@@ -0,0 +1,280 @@
+package swdocs
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver records the requests of the deliveries, answering them
+// with the codes of statuses in turn and then with 200.
+type webhookReceiver struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	statuses []int
+	requests []receivedDelivery
+}
+
+type receivedDelivery struct {
+	Header http.Header
+	Body   []byte
+	At     time.Time
+}
+
+func newWebhookReceiver(statuses ...int) *webhookReceiver {
+	rcv := &webhookReceiver{statuses: statuses}
+	rcv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		rcv.mutex.Lock()
+		defer rcv.mutex.Unlock()
+		rcv.requests = append(rcv.requests, receivedDelivery{Header: r.Header, Body: body, At: time.Now()})
+		if len(rcv.statuses) > 0 {
+			w.WriteHeader(rcv.statuses[0])
+			rcv.statuses = rcv.statuses[1:]
+		}
+	}))
+	return rcv
+}
+
+// newTestDispatcher returns a dispatcher of the app retrying quickly.
+func newTestDispatcher(a *App, retries int) *WebhookDispatcher {
+	return NewWebhookDispatcher(a.DB, &a.Mutex, &http.Client{Timeout: time.Second}, retries, 20*time.Millisecond)
+}
+
+// testEvents returns the events recorded so far, oldest first.
+func testEvents(t *testing.T, a *App) []Event {
+	t.Helper()
+	events, err := getEventsAfter(a.DB, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func createTestWebhook(t *testing.T, a *App, hook *Webhook) {
+	t.Helper()
+	if err := createWebhook(a.DB, hook); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	a := newTestApp(t)
+	rcv := newWebhookReceiver()
+	defer rcv.Close()
+
+	applyTestSwDoc(t, a, SwDoc{Name: "rabbitmq", Description: "A broker"})
+	hook := Webhook{URL: rcv.URL, Secret: "s3cr3t"}
+	createTestWebhook(t, a, &hook)
+
+	e := testEvents(t, a)[0]
+	if d := newTestDispatcher(a, 0).Send(hook, e, 1); !d.Succeeded() {
+		t.Fatalf("got status %d, error %q, want the delivery to succeed", d.StatusCode, d.Error)
+	}
+
+	if len(rcv.requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(rcv.requests))
+	}
+	req := rcv.requests[0]
+	want := SignPayload("s3cr3t", req.Body)
+	if got := req.Header.Get(SignatureHeader); !hmac.Equal([]byte(got), []byte(want)) {
+		t.Errorf("got signature %q, want %q", got, want)
+	}
+	if got := req.Header.Get(EventHeader); got != EventCreated {
+		t.Errorf("got event header %q, want %q", got, EventCreated)
+	}
+
+	var received Event
+	if err := json.Unmarshal(req.Body, &received); err != nil {
+		t.Fatal(err)
+	}
+	if received.Name != "rabbitmq" || received.SwDoc == nil || received.SwDoc.Description != "A broker" {
+		t.Errorf("got event %+v, want the creation of rabbitmq", received)
+	}
+}
+
+func TestWebhookWithoutSecretIsNotSigned(t *testing.T) {
+	a := newTestApp(t)
+	rcv := newWebhookReceiver()
+	defer rcv.Close()
+
+	applyTestSwDoc(t, a, SwDoc{Name: "rabbitmq", Description: "A broker"})
+	hook := Webhook{URL: rcv.URL}
+	createTestWebhook(t, a, &hook)
+
+	newTestDispatcher(a, 0).Send(hook, testEvents(t, a)[0], 1)
+	if len(rcv.requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(rcv.requests))
+	}
+	if got := rcv.requests[0].Header.Get(SignatureHeader); got != "" {
+		t.Errorf("got signature %q, want none", got)
+	}
+}
+
+func TestWebhookWants(t *testing.T) {
+	a := newTestApp(t)
+	applyTestSwDoc(t, a, SwDoc{Name: "payments-api", Description: "Takes payments", Labels: map[string]string{"team": "payments"}})
+	applyTestSwDoc(t, a, SwDoc{Name: "search", Description: "Finds things", Labels: map[string]string{"team": "search"}})
+	if err := a.deleteInTx("payments-api", "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	events := testEvents(t, a)
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3", len(events))
+	}
+	created, other, deleted := events[0], events[1], events[2]
+
+	tests := []struct {
+		hook Webhook
+		want []bool
+	}{
+		{Webhook{}, []bool{true, true, true}},
+		{Webhook{Events: []string{EventCreated}}, []bool{true, true, false}},
+		{Webhook{Events: []string{EventDeleted, EventRenamed}}, []bool{false, false, true}},
+		{Webhook{Selector: "team=payments"}, []bool{true, false, true}},
+		{Webhook{Selector: "team!=payments"}, []bool{false, true, false}},
+		{Webhook{Events: []string{EventDeleted}, Selector: "team=payments"}, []bool{false, false, true}},
+		{Webhook{Events: []string{EventCreated}, Selector: "team=billing"}, []bool{false, false, false}},
+	}
+	for _, tt := range tests {
+		for i, e := range []Event{created, other, deleted} {
+			if got := tt.hook.wants(e); got != tt.want[i] {
+				t.Errorf("webhook with events %v and selector %q: got wants(%s %s) = %v, want %v",
+					tt.hook.Events, tt.hook.Selector, e.Type, e.Name, got, tt.want[i])
+			}
+		}
+	}
+
+	ping := Event{Type: EventPing}
+	if (&Webhook{}).wants(ping) {
+		t.Error("got a webhook wanting an event without a SwDoc")
+	}
+}
+
+func TestWebhookRetriesWithBackoff(t *testing.T) {
+	a := newTestApp(t)
+	rcv := newWebhookReceiver(http.StatusInternalServerError, http.StatusBadGateway)
+	defer rcv.Close()
+
+	applyTestSwDoc(t, a, SwDoc{Name: "rabbitmq", Description: "A broker"})
+	hook := Webhook{URL: rcv.URL}
+	createTestWebhook(t, a, &hook)
+
+	d := newTestDispatcher(a, 3)
+	d.deliver(hook, testEvents(t, a)[0])
+
+	if len(rcv.requests) != 3 {
+		t.Fatalf("got %d requests, want 2 failures and a success", len(rcv.requests))
+	}
+	wait := d.Backoff
+	for i := 1; i < len(rcv.requests); i++ {
+		if gap := rcv.requests[i].At.Sub(rcv.requests[i-1].At); gap < wait {
+			t.Errorf("attempts %d and %d were %v apart, want at least %v", i, i+1, gap, wait)
+		}
+		wait *= 2
+	}
+
+	deliveries, err := getDeliveries(a.DB, hook.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantCodes := []int{http.StatusOK, http.StatusBadGateway, http.StatusInternalServerError}
+	if len(deliveries) != len(wantCodes) {
+		t.Fatalf("got %d deliveries logged, want %d", len(deliveries), len(wantCodes))
+	}
+	for i, delivery := range deliveries {
+		if delivery.StatusCode != wantCodes[i] || delivery.Attempt != len(wantCodes)-i {
+			t.Errorf("got delivery %d with status %d and attempt %d, want %d and %d",
+				i, delivery.StatusCode, delivery.Attempt, wantCodes[i], len(wantCodes)-i)
+		}
+	}
+}
+
+func TestWebhookGivesUpAfterRetries(t *testing.T) {
+	a := newTestApp(t)
+	rcv := newWebhookReceiver(500, 500, 500, 500, 500)
+	defer rcv.Close()
+
+	applyTestSwDoc(t, a, SwDoc{Name: "rabbitmq", Description: "A broker"})
+	hook := Webhook{URL: rcv.URL}
+	createTestWebhook(t, a, &hook)
+
+	newTestDispatcher(a, 2).deliver(hook, testEvents(t, a)[0])
+	if len(rcv.requests) != 3 {
+		t.Errorf("got %d requests, want the first attempt and 2 retries", len(rcv.requests))
+	}
+}
+
+func TestWebhookDeliveriesAreCapped(t *testing.T) {
+	a := newTestApp(t)
+	applyTestSwDoc(t, a, SwDoc{Name: "rabbitmq", Description: "A broker"})
+	hook := Webhook{URL: "http://127.0.0.1:1/unused"}
+	createTestWebhook(t, a, &hook)
+	other := Webhook{URL: "http://127.0.0.1:1/other"}
+	createTestWebhook(t, a, &other)
+
+	e := testEvents(t, a)[0]
+	for attempt := 1; attempt <= deliveriesKept+20; attempt++ {
+		d := WebhookDelivery{WebhookID: hook.ID, EventID: e.ID, EventType: e.Type, Attempt: attempt, StatusCode: 200}
+		if err := saveDelivery(a.DB, d, deliveriesKept); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := saveDelivery(a.DB, WebhookDelivery{WebhookID: other.ID, EventID: e.ID, EventType: e.Type, Attempt: 1}, deliveriesKept); err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries, err := getDeliveries(a.DB, hook.ID, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != deliveriesKept {
+		t.Fatalf("got %d deliveries kept, want %d", len(deliveries), deliveriesKept)
+	}
+	if newest, oldest := deliveries[0].Attempt, deliveries[len(deliveries)-1].Attempt; newest != deliveriesKept+20 || oldest != 21 {
+		t.Errorf("got attempts %d to %d kept, want the last ones, 21 to %d", oldest, newest, deliveriesKept+20)
+	}
+
+	deliveries, err = getDeliveries(a.DB, other.ID, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Errorf("got %d deliveries of the other webhook, want 1", len(deliveries))
+	}
+}
+
+func TestWebhookRefusesPrivateAddresses(t *testing.T) {
+	a := newTestApp(t)
+	rcv := newWebhookReceiver()
+	defer rcv.Close()
+
+	hook := Webhook{URL: rcv.URL}
+	createTestWebhook(t, a, &hook)
+
+	rec := serveTestRequest(a, "POST", fmt.Sprintf("/api/v1/webhooks/%d/ping", hook.ID), "")
+	var delivery WebhookDelivery
+	if err := json.Unmarshal(rec.Body.Bytes(), &delivery); err != nil {
+		t.Fatal(err)
+	}
+	if delivery.Succeeded() || delivery.StatusCode != 0 || delivery.Error == "" {
+		t.Errorf("got delivery %+v, want the connection to be refused", delivery)
+	}
+	if len(rcv.requests) != 0 {
+		t.Errorf("got %d requests to the private address, want none", len(rcv.requests))
+	}
+
+	allowed := NewWebhookDispatcher(a.DB, &a.Mutex, NewWebhookClient(time.Second, true), 0, 0)
+	if d := allowed.Send(hook, Event{Type: EventPing}, 1); !d.Succeeded() {
+		t.Errorf("got delivery %+v, want private addresses delivered to when allowed", d)
+	}
+}